@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BugenZhao/sql-masker/mask"
+	"github.com/Jeffail/tunny"
+	"github.com/pingcap/parser/ast"
+	"go.uber.org/zap"
+)
+
+type CSVOption struct {
+	Concurrency     int    `opts:"short=t, help=goroutine concurrency for masking, default=CPU nums"`
+	InputDir        string `opts:"help=directory to the original data files like db.table.000.csv"`
+	OutputDir       string `opts:"help=directory to the masked data files"`
+	Separator       string `opts:"help=field separator (default tab for tsv files and comma otherwise)"`
+	Delimiter       string `opts:"help=quote character of fields or empty if never quoted"`
+	Null            string `opts:"help=representation of NULL"`
+	Header          bool   `opts:"help=whether the first row of files is the header"`
+	BackslashEscape bool   `opts:"help=whether backslash escapes are used in fields"`
+}
+
+// Split data file name `db.table.000.csv` into the table name `db.table` and the rest
+func splitDataFileName(path string) (table string, rest string, err error) {
+	base := filepath.Base(path)
+	tokens := strings.SplitN(base, ".", 3)
+	if len(tokens) < 3 {
+		return "", "", fmt.Errorf("bad data file name: `%s`", base)
+	}
+	return strings.ToLower(tokens[0] + "." + tokens[1]), tokens[2], nil
+}
+
+func (opt *CSVOption) format(path string) (mask.CSVFormat, error) {
+	format := mask.CSVFormat{
+		Separator:       ',',
+		Null:            opt.Null,
+		Header:          opt.Header,
+		BackslashEscape: opt.BackslashEscape,
+	}
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		format.Separator = '\t'
+	}
+
+	if opt.Separator != "" {
+		if len(opt.Separator) != 1 {
+			return format, fmt.Errorf("separator `%s` should be a single character", opt.Separator)
+		}
+		format.Separator = opt.Separator[0]
+	}
+	if len(opt.Delimiter) > 1 {
+		return format, fmt.Errorf("delimiter `%s` should be a single character", opt.Delimiter)
+	} else if len(opt.Delimiter) == 1 {
+		format.Delimiter = opt.Delimiter[0]
+	}
+	return format, nil
+}
+
+// Read tables from schema files like `db.table-schema.sql` under `DDLDir`
+func readDumpTables() (map[string]*mask.DumpTable, error) {
+	tables := map[string]*mask.DumpTable{}
+	for _, dir := range globalOption.DDLDir {
+		ddlPaths, _ := filepath.Glob(filepath.Join(dir, "*.*-schema.sql"))
+		for _, path := range ddlPaths {
+			info, err := newDDLInfo(path)
+			if err != nil {
+				return nil, err
+			}
+			create, ok := info.stmt.(*ast.CreateTableStmt)
+			if !ok {
+				continue
+			}
+			table, err := mask.NewDumpTable(info.db, create)
+			if err != nil {
+				return nil, err
+			}
+			tables[info.Prefix()] = table
+		}
+	}
+	return tables, nil
+}
+
+func (opt *CSVOption) outPath(from string) string {
+	base := filepath.Base(from)
+	nameMap := globalOption.ReadNameMap()
+	if table, rest, err := splitDataFileName(from); err == nil && nameMap != nil {
+		base = nameMap.FullTableName(table) + "." + rest
+	}
+	return filepath.Join(opt.OutputDir, base)
+}
+
+// Run masking for the single data file at `path`, returns `Stats`
+func (opt *CSVOption) RunFile(path string, tables map[string]*mask.DumpTable) (*mask.Stats, error) {
+	maskFunc := globalOption.ResolveMaskFunc()
+
+	tableName, _, err := splitDataFileName(path)
+	if err != nil {
+		return nil, err
+	}
+	table, ok := tables[tableName]
+	if !ok {
+		return nil, fmt.Errorf("schema of table `%s` not found", tableName)
+	}
+	format, err := opt.format(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	nameMap := globalOption.ReadNameMap()
+	policy, err := globalOption.ReadMaskPolicy()
+	if err != nil {
+		return nil, err
+	}
+	masker := mask.NewCSVWorker(table, format, maskFunc, policy, globalOption.IgnoreIntPK, nameMap)
+
+	outPath := opt.outPath(path)
+	if _, err := os.Stat(outPath); err == nil {
+		return nil, fmt.Errorf("file %s already exists", outPath)
+	}
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		return nil, err
+	}
+	defer outFile.Close()
+
+	err = masker.MaskAll(file, outFile)
+	if err != nil {
+		// do not leave partially masked files
+		_ = os.Remove(outPath)
+		return nil, err
+	}
+	return &masker.Stats, nil
+}
+
+// Entry for `csv` subcommand.
+func (opt *CSVOption) Run() error {
+	if opt.OutputDir == "" {
+		return fmt.Errorf("output dir not given")
+	}
+	err := os.MkdirAll(opt.OutputDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = globalOption.ApplySecret()
+	if err != nil {
+		return err
+	}
+	tables, err := readDumpTables()
+	if err != nil {
+		return err
+	}
+
+	csvPaths, _ := filepath.Glob(filepath.Join(opt.InputDir, "*.csv"))
+	tsvPaths, _ := filepath.Glob(filepath.Join(opt.InputDir, "*.tsv"))
+	paths := append(csvPaths, tsvPaths...)
+	wg := new(sync.WaitGroup)
+	resultChan := make(chan TaskResult)
+
+	pool := tunny.NewFunc(opt.Concurrency, func(arg interface{}) interface{} {
+		defer wg.Done()
+		path := arg.(string)
+		stats, err := opt.RunFile(path, tables)
+		resultChan <- TaskResult{
+			from:  path,
+			to:    opt.outPath(path),
+			stats: stats,
+			err:   err,
+		}
+		return nil
+	})
+	defer pool.Close()
+
+	zap.S().Infow("start masking data files...")
+	for _, path := range paths {
+		wg.Add(1)
+		go pool.Process(path)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	i := 1
+	all := len(paths)
+	stats := mask.Stats{}
+	startTime := time.Now()
+	for result := range resultChan {
+		progress := fmt.Sprintf("%d/%d", i, all)
+		if result.err != nil {
+			zap.S().Warnw("mask error", "progress", progress, "file", result.from, "error", result.err)
+		} else {
+			zap.S().Infow("mask done", "progress", progress, "from", result.from, "to", result.to, "stats", result.stats.String())
+			stats.Merge(*result.stats)
+		}
+		i += 1
+	}
+
+	zap.S().Infow("all done", "files", all, "stats", stats, "time", time.Since(startTime).String())
+	return nil
+}
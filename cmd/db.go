@@ -36,9 +36,8 @@ func initializeDB() error {
 	}
 
 	for _, dir := range globalOption.DDLDir {
-		ddls := make(chan string)
 		paths, _ := filepath.Glob(dir + "/*.sql")
-		go ReadSQLs(ddls, paths...)
+		ddls, readErr := readSQLsInBackground(paths...)
 		for sql := range ddls {
 			if globalOption.FilterOutConstraints {
 				if globalOption.IgnoreIntPK {
@@ -54,6 +53,9 @@ func initializeDB() error {
 				return err
 			}
 		}
+		if err := <-readErr; err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -76,15 +78,17 @@ func NewPreparedTiDBContext() (*tidb.Context, error) {
 	_ = db.UseDB(globalOption.DB)
 
 	for _, dir := range globalOption.PrepareDir {
-		ddls := make(chan string)
 		paths, _ := filepath.Glob(dir + "/*.sql")
-		go ReadSQLs(ddls, paths...)
+		ddls, readErr := readSQLsInBackground(paths...)
 		for sql := range ddls {
 			err = db.Execute(sql)
 			if err != nil {
 				return nil, err
 			}
 		}
+		if err := <-readErr; err != nil {
+			return nil, err
+		}
 	}
 
 	return db, nil
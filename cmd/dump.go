@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BugenZhao/sql-masker/mask"
+	"github.com/pingcap/parser"
+	"go.uber.org/zap"
+)
+
+type DumpOption struct {
+	Inputs      []string `opts:"mode=arg, name=input, help=dump files to mask or - to read from stdin"`
+	Output      string   `opts:"help=path to the masked dump instead of stdout"`
+	Concurrency int      `opts:"short=t, help=goroutine concurrency for masking, default=CPU nums"`
+}
+
+type dumpResult struct {
+	sql string
+	err error
+}
+
+// A statement in the dump, `schema` is nil if it's masked in order by the dispatcher
+type dumpJob struct {
+	sql    string
+	schema *mask.DumpSchema
+	result chan dumpResult
+}
+
+// Strip leading spaces and non-executable comments of `sql`
+func trimLeadingComments(sql string) string {
+	for {
+		sql = strings.TrimSpace(sql)
+		switch {
+		case strings.HasPrefix(sql, "--"), strings.HasPrefix(sql, "#"):
+			end := strings.IndexByte(sql, '\n')
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+1:]
+		case strings.HasPrefix(sql, "/*") && !strings.HasPrefix(sql, "/*!") && !strings.HasPrefix(sql, "/*+"):
+			end := strings.Index(sql, "*/")
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+2:]
+		default:
+			return sql
+		}
+	}
+}
+
+// Check whether `sql` is an `INSERT` or `REPLACE` statement without parsing it
+func isInsertLike(sql string) bool {
+	sql = trimLeadingComments(sql)
+	for _, keyword := range []string{"INSERT", "REPLACE"} {
+		if len(sql) > len(keyword) && strings.EqualFold(sql[:len(keyword)], keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminate `sql` without delimiter with `;`, statements with `;` inside like stored procedures
+// are wrapped with `DELIMITER` commands, so that the output can be loaded by MySQL client
+func terminateStmt(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if strings.Contains(sql, ";") {
+		return fmt.Sprintf("DELIMITER ;;\n%s;;\nDELIMITER ;\n", sql)
+	}
+	return sql + ";\n"
+}
+
+// Read statements, learn the schema and dispatch `INSERT`s to `jobs` for masking. Other
+// statements like DDLs are masked in order by `masker`, since they may change the schema.
+// All statements are also sent to `ordered` to keep the original order in output.
+func (opt *DumpOption) dispatch(sqls <-chan string, masker *mask.SQLWorker, jobs chan<- *dumpJob, ordered chan<- *dumpJob) {
+	defer close(ordered)
+	defer close(jobs)
+
+	p := parser.New()
+	schema := mask.NewDumpSchema(globalOption.DB)
+
+	for sql := range sqls {
+		job := &dumpJob{
+			sql:    sql,
+			result: make(chan dumpResult, 1),
+		}
+
+		if isInsertLike(sql) {
+			job.schema = schema
+			ordered <- job
+			jobs <- job
+			continue
+		}
+
+		stmt, err := p.ParseOneStmt(sql, "", "")
+		if err == nil {
+			schema, err = schema.Learn(stmt)
+		}
+		if err != nil && globalOption.Verbose {
+			zap.S().Warnw("failed to learn schema from statement", "sql", sql, "error", err)
+		}
+		newSQL, err := masker.MaskOne(sql)
+		job.result <- dumpResult{newSQL, err}
+		ordered <- job
+	}
+}
+
+// Entry for `dump` subcommand.
+func (opt *DumpOption) Run() error {
+	maskFunc := globalOption.ResolveMaskFunc()
+	err := globalOption.ApplySecret()
+	if err != nil {
+		return err
+	}
+	err = globalOption.ApplySkipList()
+	if err != nil {
+		return err
+	}
+	policy, err := globalOption.ReadMaskPolicy()
+	if err != nil {
+		return err
+	}
+	nameMap := globalOption.ReadNameMap()
+
+	if len(opt.Inputs) == 0 {
+		return fmt.Errorf("no dump file given, use `-` to read from stdin")
+	}
+	if opt.Concurrency <= 0 {
+		return fmt.Errorf("bad concurrency `%d`", opt.Concurrency)
+	}
+
+	var outFile io.Writer = os.Stdout
+	if opt.Output != "" {
+		file, err := os.Create(opt.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		outFile = file
+	}
+	out := bufio.NewWriter(outFile)
+	defer out.Flush()
+
+	db, err := NewPreparedTiDBContext()
+	if err != nil {
+		return err
+	}
+	masker := mask.NewSQLWorker(db, maskFunc, policy, globalOption.IgnoreIntPK, nameMap)
+
+	jobs := make(chan *dumpJob, opt.Concurrency*4)
+	ordered := make(chan *dumpJob, opt.Concurrency*4)
+
+	workers := make([]*mask.DumpWorker, opt.Concurrency)
+	wg := new(sync.WaitGroup)
+	for i := range workers {
+		workers[i] = mask.NewDumpWorker(maskFunc, policy, globalOption.IgnoreIntPK, nameMap)
+		wg.Add(1)
+		go func(w *mask.DumpWorker) {
+			defer wg.Done()
+			for job := range jobs {
+				sql, err := w.MaskOne(job.sql, job.schema)
+				job.result <- dumpResult{sql, err}
+			}
+		}(workers[i])
+	}
+
+	zap.S().Infow("start masking dump...")
+	startTime := time.Now()
+	sqls, readErr := readSQLsInBackground(opt.Inputs...)
+	go opt.dispatch(sqls, masker, jobs, ordered)
+
+	for job := range ordered {
+		result := <-job.result
+		sql := result.sql
+		if result.err != nil {
+			if globalOption.Verbose {
+				zap.S().Warnw("failed to mask statement", "sql", job.sql, "error", result.err)
+			}
+			if sql == "" { // failed, only the error is written to keep values out of the dump
+				_, err := fmt.Fprintf(out, "-- FAILED: %s\n", strings.ReplaceAll(result.err.Error(), "\n", " "))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		_, err := out.WriteString(terminateStmt(sql))
+		if err != nil {
+			return err
+		}
+	}
+	wg.Wait()
+	err = <-readErr
+	if err != nil {
+		return err
+	}
+
+	stats := masker.Stats
+	for _, w := range workers {
+		stats.Merge(w.Stats)
+	}
+	zap.S().Infow("all done", "stats", stats, "time", time.Since(startTime).String())
+	return out.Flush()
+}
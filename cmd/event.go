@@ -42,7 +42,11 @@ func (opt *EventOption) RunFile(path string) (*mask.Stats, error) {
 	}
 
 	nameMap := globalOption.ReadNameMap()
-	masker := mask.NewEventWorker(db, maskFunc, globalOption.IgnoreIntPK, nameMap)
+	policy, err := globalOption.ReadMaskPolicy()
+	if err != nil {
+		return nil, err
+	}
+	masker := mask.NewEventWorker(db, maskFunc, policy, globalOption.IgnoreIntPK, nameMap)
 
 	outPath := opt.outPath(file.Name())
 	if _, err := os.Stat(outPath); err == nil {
@@ -97,6 +101,14 @@ func (opt *EventOption) Run() error {
 	if err != nil {
 		return err
 	}
+	err = globalOption.ApplySecret()
+	if err != nil {
+		return err
+	}
+	err = globalOption.ApplySkipList()
+	if err != nil {
+		return err
+	}
 
 	paths, _ := filepath.Glob(opt.InputDir + "/*")
 	wg := new(sync.WaitGroup)
@@ -143,6 +155,7 @@ func (opt *EventOption) Run() error {
 		i += 1
 	}
 
-	zap.S().Infow("all done", "files", all, "stats", stats, "time", time.Since(startTime).String())
+	zap.S().Infow("all done", "files", all, "stats", stats.String(), "time", time.Since(startTime).String())
+	stats.PrintUnmapped(os.Stderr)
 	return nil
 }
@@ -22,5 +22,10 @@ func (o *ListOption) Run() error {
 		fmt.Printf("%s:\n\t%s\n", name, mask.MaskFuncMap[name].Description)
 	}
 
+	fmt.Println("\nAll avaliable skip rules of structural literals:")
+	for _, rule := range mask.SkipRules {
+		fmt.Printf("%s:\n\t%s\n", rule.Name, rule.Description)
+	}
+
 	return nil
 }
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/BugenZhao/sql-masker/mask"
+	"github.com/BugenZhao/sql-masker/tidb"
 	"github.com/pingcap/parser"
 	"github.com/pingcap/parser/ast"
 	"go.uber.org/zap"
@@ -17,28 +18,74 @@ import (
 type NameOption struct {
 	MaskedDBPrefix string `opts:"help=prefix of masked schema files"`
 	Output         string `opts:"help=path to the output name map"`
+	Scheme         string `opts:"help=generate names by scheme dict or index from original schema files only"`
+	MaskedDir      string `opts:"help=directory to write masked schema files generated by scheme"`
 }
 
 func (opt *NameOption) isMaskedInfo(info *ddlInfo) bool {
 	return strings.HasPrefix(info.db, opt.MaskedDBPrefix)
 }
 
-func (opt *NameOption) handleDir(dir string, columns map[string]string) error {
-	ddlPaths, _ := filepath.Glob(filepath.Join(dir, "*.*-schema.sql"))
+// Suffixes of schema files of tables, views and sequences
+var schemaFileSuffixes = []string{"-schema.sql", "-schema-view.sql", "-schema-sequence.sql"}
 
-	origInfos := []*ddlInfo{}
-	maskedInfos := []*ddlInfo{}
+func isSchemaFile(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	for _, suffix := range schemaFileSuffixes {
+		if strings.HasSuffix(base, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read schema files of tables, views and sequences in `dir`, split into original ones and masked
+// ones
+func (opt *NameOption) readDir(dir string) (origInfos []*ddlInfo, maskedInfos []*ddlInfo, _ error) {
+	ddlPaths, _ := filepath.Glob(filepath.Join(dir, "*.*-schema*.sql"))
+
+	infos := map[string]*ddlInfo{}
+	prefixes := []string{}
 	for _, path := range ddlPaths {
+		// other schema files like `-schema-triggers.sql` and `-schema-post.sql` define no objects to map
+		if !isSchemaFile(path) {
+			zap.S().Infow("skipped schema file", "path", path)
+			continue
+		}
 		info, err := newDDLInfo(path)
 		if err != nil {
-			return err
+			return nil, nil, err
+		}
+		// a view may also be dumped as a table with its columns
+		if other, ok := infos[info.Prefix()]; ok {
+			if _, ok := info.stmt.(*ast.CreateViewStmt); !ok {
+				info, other = other, info
+			}
+			if len(info.cols) == 0 {
+				info.cols = other.cols
+			}
+		} else {
+			prefixes = append(prefixes, info.Prefix())
 		}
+		infos[info.Prefix()] = info
+	}
+
+	for _, prefix := range prefixes {
+		info := infos[prefix]
 		if opt.isMaskedInfo(info) {
 			maskedInfos = append(maskedInfos, info)
 		} else {
 			origInfos = append(origInfos, info)
 		}
 	}
+	return origInfos, maskedInfos, nil
+}
+
+func (opt *NameOption) handleDir(dir string, nameMap *mask.NameMap) error {
+	origInfos, maskedInfos, err := opt.readDir(dir)
+	if err != nil {
+		return err
+	}
 
 	if len(origInfos) != len(maskedInfos) {
 		return fmt.Errorf("bad number of masked ddls")
@@ -50,14 +97,21 @@ func (opt *NameOption) handleDir(dir string, columns map[string]string) error {
 		o := origInfos[i]
 		m := maskedInfos[i]
 
-		if len(o.stmt.Cols) != len(m.stmt.Cols) {
+		if len(o.cols) != len(m.cols) {
 			return fmt.Errorf("bad number of columns for `%s` and `%s`", o.Prefix(), m.Prefix())
 		}
 
-		for j := range o.stmt.Cols {
-			oCol := fmt.Sprintf("%s.%s", o.Prefix(), o.stmt.Cols[j].Name.Name.L)
-			mCol := fmt.Sprintf("%s.%s", m.Prefix(), m.stmt.Cols[j].Name.Name.L)
-			columns[oCol] = mCol
+		nameMap.DBs[o.db] = m.db
+		nameMap.Tables[o.Prefix()] = m.Prefix()
+		for j := range o.cols {
+			oCol := fmt.Sprintf("%s.%s", o.Prefix(), o.cols[j])
+			mCol := fmt.Sprintf("%s.%s", m.Prefix(), m.cols[j])
+			nameMap.Columns[oCol] = mCol
+		}
+		oCreate, oOk := o.stmt.(*ast.CreateTableStmt)
+		mCreate, mOk := m.stmt.(*ast.CreateTableStmt)
+		if oOk && mOk {
+			nameMap.PairTableObjects(o.Prefix(), oCreate, m.Prefix(), mCreate)
 		}
 	}
 
@@ -66,14 +120,23 @@ func (opt *NameOption) handleDir(dir string, columns map[string]string) error {
 
 func (opt *NameOption) Run() error {
 	opt.MaskedDBPrefix = strings.ToLower(opt.MaskedDBPrefix)
+	if opt.Scheme != "" {
+		return opt.generate()
+	}
 
-	columns := map[string]string{}
+	nameMap := mask.NewGlobalNameMap(map[string]string{})
 
 	for _, dir := range globalOption.DDLDir {
-		opt.handleDir(dir, columns)
+		err := opt.handleDir(dir, nameMap)
+		if err != nil {
+			return err
+		}
 	}
 
-	nameMap := mask.NewGlobalNameMap(columns)
+	return opt.writeNameMap(nameMap)
+}
+
+func (opt *NameOption) writeNameMap(nameMap *mask.NameMap) error {
 	bytes, err := json.MarshalIndent(nameMap, "", "\t")
 	if err != nil {
 		return err
@@ -92,13 +155,165 @@ func (opt *NameOption) Run() error {
 	return nil
 }
 
+// Generate the name map and masked schema files by `Scheme` from original schema files only,
+// so that no pre-masked schema is required
+func (opt *NameOption) generate() error {
+	if opt.MaskedDir == "" {
+		return fmt.Errorf("masked dir must be given to generate names by scheme")
+	}
+	// the dictionary of the generator is keyed by the secret
+	err := globalOption.ApplySecret()
+	if err != nil {
+		return err
+	}
+	g, err := mask.NewNameGenerator(opt.Scheme)
+	if err != nil {
+		return err
+	}
+
+	infos := []*ddlInfo{}
+	createDBPaths := map[string]string{}
+	for _, dir := range globalOption.DDLDir {
+		// masked schema files are ignored if there're any
+		origInfos, _, err := opt.readDir(dir)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, origInfos...)
+		for _, info := range origInfos {
+			createDBPaths[info.db] = filepath.Join(dir, fmt.Sprintf("%s-schema-create.sql", info.db))
+		}
+	}
+	sort.Sort(bySchemaName(infos))
+
+	for _, info := range infos {
+		_, err := g.Add(info.db, info.stmt, info.cols)
+		if err != nil {
+			return err
+		}
+	}
+	nameMap := g.NameMap()
+
+	masked, db, err := maskSchema(infos, nameMap)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(opt.MaskedDir, 0777)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		err = writeSchemaFile(opt.MaskedDir, nameMap.FullTableName(info.Prefix()), info.suffix, masked[info])
+		if err != nil {
+			return err
+		}
+	}
+	for dbName, path := range createDBPaths {
+		stmt := &ast.CreateDatabaseStmt{IfNotExists: true}
+		if bytes, err := os.ReadFile(path); err == nil {
+			node, err := parser.New().ParseOneStmt(string(bytes), "", "")
+			if err != nil {
+				return err
+			}
+			if create, ok := node.(*ast.CreateDatabaseStmt); ok {
+				stmt = create
+			}
+		}
+		stmt.Name = nameMap.DB(dbName)
+		sql, err := db.RestoreSQL(stmt)
+		if err != nil {
+			return err
+		}
+		err = writeSchemaFile(opt.MaskedDir, stmt.Name, "schema-create", sql)
+		if err != nil {
+			return err
+		}
+	}
+	zap.S().Infow("generated masked schema", "objects", len(infos), "dir", opt.MaskedDir)
+
+	return opt.writeNameMap(nameMap)
+}
+
+// Mask schema statements of `infos` with a worker on a new instance, so that literals and
+// comments are masked like other DDLs, and names are mapped by `nameMap`. Statements are
+// executed in turn, and those depending on later ones like views are retried after them.
+func maskSchema(infos []*ddlInfo, nameMap *mask.NameMap) (map[*ddlInfo]string, *tidb.Context, error) {
+	policy, err := globalOption.ReadMaskPolicy()
+	if err != nil {
+		return nil, nil, err
+	}
+	instance, err := tidb.NewInstance()
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := instance.OpenContext()
+	if err != nil {
+		return nil, nil, err
+	}
+	masker := mask.NewSQLWorker(db, schemaMaskFunc(), policy, globalOption.IgnoreIntPK, nameMap)
+
+	masked := map[*ddlInfo]string{}
+	pending := infos
+	for len(pending) > 0 {
+		failed := []*ddlInfo{}
+		var lastErr error
+		for _, info := range pending {
+			err := db.MayCreateDB(info.db)
+			if err == nil {
+				err = db.UseDB(info.db)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			sql, err := db.RestoreSQL(info.stmt)
+			if err != nil {
+				return nil, nil, err
+			}
+			newSQL, err := masker.MaskOne(sql)
+			if newSQL == "" {
+				failed = append(failed, info)
+				lastErr = fmt.Errorf("failed to mask schema of `%s`; %w", info.Prefix(), err)
+				continue
+			}
+			if err != nil {
+				zap.S().Warnw("problematic schema", "table", info.Prefix(), "error", err)
+			}
+			masked[info] = newSQL
+		}
+		if len(failed) == len(pending) {
+			return nil, nil, lastErr
+		}
+		pending = failed
+	}
+	return masked, db, nil
+}
+
+// Mask function for schema files, which must be loadable without original values, so functions
+// for debugging are replaced with `format-preserving`
+func schemaMaskFunc() mask.MaskFunc {
+	switch name := strings.ToLower(globalOption.Mask); name {
+	case "debug", "debug-color", "identical":
+		zap.S().Infow("use format-preserving mask function for schema files", "mask", name)
+		return mask.MaskFuncMap["format-preserving"]
+	default:
+		return globalOption.ResolveMaskFunc()
+	}
+}
+
+// Write `sql` to a schema file named like `db.table-schema.sql` in `dir`
+func writeSchemaFile(dir string, name string, suffix string, sql string) error {
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.sql", name, suffix))
+	return os.WriteFile(path, []byte(sql+";\n"), 0666)
+}
+
 func newDDLInfo(path string) (*ddlInfo, error) {
-	base := filepath.Base(path)
-	prefix := strings.ToLower(strings.TrimSuffix(base, "-schema.sql"))
+	base := strings.ToLower(strings.TrimSuffix(filepath.Base(path), ".sql"))
+	i := strings.LastIndex(base, "-schema")
+	prefix, suffix := base[:i], base[i+1:]
 
 	tokens := strings.Split(prefix, ".")
 	if len(tokens) != 2 {
-		return nil, fmt.Errorf("bad schema file name: `%s`", base)
+		return nil, fmt.Errorf("bad schema file name: `%s`", filepath.Base(path))
 	}
 	db, table := tokens[0], tokens[1]
 
@@ -108,23 +323,40 @@ func newDDLInfo(path string) (*ddlInfo, error) {
 	}
 	str := string(bytes)
 	p := parser.New()
-	node, err := p.ParseOneStmt(str, "", "")
+	nodes, _, err := p.Parse(str, "", "")
 	if err != nil {
 		return nil, err
 	}
 
-	if create, ok := node.(*ast.CreateTableStmt); ok {
-		return &ddlInfo{
-			db, table, create,
-		}, nil
+	// dumped views may be dropped before created
+	for _, node := range nodes {
+		info := &ddlInfo{db: db, table: table, stmt: node, suffix: suffix}
+		switch node := node.(type) {
+		case *ast.CreateTableStmt:
+			for _, col := range node.Cols {
+				info.cols = append(info.cols, col.Name.Name.L)
+			}
+		case *ast.CreateViewStmt:
+			for _, col := range node.Cols {
+				info.cols = append(info.cols, col.L)
+			}
+		case *ast.CreateSequenceStmt:
+		default:
+			continue
+		}
+		return info, nil
 	}
-	return nil, fmt.Errorf("not a create table statement in `%s`", path)
+	return nil, fmt.Errorf("not a create table, view or sequence statement in `%s`", path)
 }
 
 type ddlInfo struct {
 	db    string
 	table string
-	stmt  *ast.CreateTableStmt
+	// `CREATE TABLE`, `CREATE VIEW` or `CREATE SEQUENCE`
+	stmt ast.StmtNode
+	cols []string
+	// suffix of the file name like `schema` or `schema-view`
+	suffix string
 }
 
 func (info *ddlInfo) Prefix() string {
@@ -16,6 +16,8 @@ type Option struct {
 	EventOption          `opts:"mode=cmd, name=event, help=Mask MySQL events"`
 	ListOption           `opts:"mode=cmd, name=list,  help=List all mask functions"`
 	NameOption           `opts:"mode=cmd, name=name,  help=Generate name maps"`
+	DumpOption           `opts:"mode=cmd, name=dump,  help=Mask data dumps like mysqldump output"`
+	CSVOption            `opts:"mode=cmd, name=csv,   help=Mask CSV / TSV data files"`
 	DDLDir               []string `opts:"help=directories to DDL SQL files executed only once"`
 	PrepareDir           []string `opts:"help=directories to SQL files executed per session"`
 	DB                   string   `opts:"help=default database to use"`
@@ -24,12 +26,28 @@ type Option struct {
 	Mask                 string   `opts:"help=name of the mask function"`
 	Verbose              bool     `opts:"help=whether to print warnings for failed entry"`
 	NameMapPath          string   `opts:"name=name-map, help=path to name map"`
+	NameMapStrict        bool     `opts:"name=name-map-strict, help=whether to fail statements with identifiers not found in the name map"`
+	Secret               string   `opts:"help=secret for keyed masking so that masked output cannot be reversed without it"`
+	KeyFile              string   `opts:"help=path to a file containing the secret for keyed masking"`
+	MaskPolicyPath       string   `opts:"name=mask-policy, help=path to a YAML or JSON per-column mask policy"`
+	SkipRules            []string `opts:"name=skip-rule, help=rules of structural literals never masked (all by default; see the list command)"`
+	SkipArgs             []string `opts:"name=skip-arg, help=extra structural arguments of functions never masked like json_set:2 for the second argument"`
 }
 
 var globalOption = &Option{
 	EventOption: EventOption{
 		Concurrency: runtime.NumCPU(),
 	},
+	DumpOption: DumpOption{
+		Concurrency: runtime.NumCPU(),
+	},
+	CSVOption: CSVOption{
+		Concurrency:     runtime.NumCPU(),
+		Delimiter:       `"`,
+		Null:            `\N`,
+		Header:          true,
+		BackslashEscape: true,
+	},
 	NameOption: NameOption{
 		MaskedDBPrefix: "_mdb",
 	},
@@ -54,6 +72,10 @@ func (o *Option) ResolveMaskFunc() mask.MaskFunc {
 var (
 	nameMap     mask.NameMap
 	nameMapOnce sync.Once
+
+	maskPolicy     *mask.MaskPolicy
+	maskPolicyErr  error
+	maskPolicyOnce sync.Once
 )
 
 // Read `NameMap` for db/table/col name masking,
@@ -72,6 +94,7 @@ func (o *Option) ReadNameMap() *mask.NameMap {
 		if err != nil {
 			panic(fmt.Errorf("bad name map format; %w", err))
 		}
+		nameMap.SetStrict(o.NameMapStrict)
 	})
 
 	if len(nameMap.Columns) == 0 {
@@ -80,3 +103,55 @@ func (o *Option) ReadNameMap() *mask.NameMap {
 		return &nameMap
 	}
 }
+
+// Read the secret from `--secret` or `--key-file`, then apply it to all mask functions
+// and name dictionaries. Keeps the unkeyed default if neither is given.
+func (o *Option) ApplySecret() error {
+	if o.Secret != "" && o.KeyFile != "" {
+		return fmt.Errorf("only one of secret and key file can be given")
+	}
+
+	secret := []byte(o.Secret)
+	if o.KeyFile != "" {
+		bytes, err := os.ReadFile(o.KeyFile)
+		if err != nil {
+			return err
+		}
+		secret = []byte(strings.TrimRight(string(bytes), "\r\n"))
+		if len(secret) == 0 {
+			return fmt.Errorf("empty key file `%s`", o.KeyFile)
+		}
+	}
+
+	mask.SetSecret(secret)
+	return nil
+}
+
+// Build the skip-list of structural literals from `--skip-rule` and `--skip-arg`, then apply it
+// to all workers
+func (o *Option) ApplySkipList() error {
+	l, err := mask.NewSkipList(o.SkipRules, o.SkipArgs)
+	if err != nil {
+		return err
+	}
+	mask.SetSkipList(l)
+	return nil
+}
+
+// Read the per-column `MaskPolicy`, returns nil if not provided
+func (o *Option) ReadMaskPolicy() (*mask.MaskPolicy, error) {
+	maskPolicyOnce.Do(func() {
+		if o.MaskPolicyPath == "" {
+			return
+		}
+
+		bytes, err := os.ReadFile(o.MaskPolicyPath)
+		if err != nil {
+			maskPolicyErr = err
+			return
+		}
+		maskPolicy, maskPolicyErr = mask.ParseMaskPolicy(bytes)
+	})
+
+	return maskPolicy, maskPolicyErr
+}
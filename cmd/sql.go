@@ -1,18 +1,176 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/BugenZhao/sql-masker/mask"
 	"github.com/fatih/color"
+	"go.uber.org/zap"
 )
 
 type SQLOption struct {
-	File string `opts:"help=SQL file to mask"`
+	File       string   `opts:"help=SQL file to mask"`
+	Inputs     []string `opts:"mode=arg, name=input, help=SQL files to mask or - to read from stdin"`
+	Format     string   `opts:"help=output format: text / sql / json / jsonl (default sql when reading from stdin and text otherwise)"`
+	Output     string   `opts:"help=path to the output file instead of stdout"`
+	ShowValues bool     `opts:"help=whether to include original values of constants in json output"`
+}
+
+// Writer of masking results in a specific format
+type sqlResultWriter interface {
+	Write(result *mask.SQLResult) error
+	Close() error
+}
+
+func newSQLResultWriter(format string, out io.Writer) (sqlResultWriter, error) {
+	switch format {
+	case "", "text":
+		return &textResultWriter{out}, nil
+	case "sql":
+		return &sqlOnlyResultWriter{out}, nil
+	case "json":
+		return &jsonResultWriter{out: out, first: true}, nil
+	case "jsonl":
+		return &jsonResultWriter{out: out, lines: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format `%s`", format)
+	}
+}
+
+// Human-readable output with colors
+type textResultWriter struct {
+	out io.Writer
+}
+
+func (w *textResultWriter) Write(result *mask.SQLResult) error {
+	fmt.Fprintf(w.out, "\n-> %s\n", result.Original)
+	switch result.Status {
+	case mask.StatusProblematic:
+		fmt.Fprint(w.out, color.YellowString("?> %s\n", strings.Join(result.Errors, "; ")))
+	case mask.StatusFailed:
+		fmt.Fprint(w.out, color.RedString("!> %s\n", strings.Join(result.Errors, "; ")))
+		return nil
+	}
+	_, err := fmt.Fprintf(w.out, "=> %s\n", result.Masked)
+	return err
+}
+
+func (w *textResultWriter) Close() error {
+	return nil
+}
+
+// Masked statements only, failed ones are replaced with a comment of errors, so that the
+// output can still be replayed without any unmasked statement
+type sqlOnlyResultWriter struct {
+	out io.Writer
+}
+
+func (w *sqlOnlyResultWriter) Write(result *mask.SQLResult) error {
+	if result.Status == mask.StatusFailed {
+		errors := strings.ReplaceAll(strings.Join(result.Errors, "; "), "\n", " ")
+		_, err := fmt.Fprintf(w.out, "-- FAILED: %s\n", errors)
+		return err
+	}
+	_, err := fmt.Fprint(w.out, terminateStmt(result.Masked))
+	return err
+}
+
+func (w *sqlOnlyResultWriter) Close() error {
+	return nil
+}
+
+// A JSON array of results, or one result per line if `lines`
+type jsonResultWriter struct {
+	out   io.Writer
+	lines bool
+	first bool
+}
+
+func (w *jsonResultWriter) Write(result *mask.SQLResult) error {
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	if !w.lines {
+		if w.first {
+			prefix = "[\n"
+			w.first = false
+		} else {
+			prefix = ",\n"
+		}
+	}
+	suffix := ""
+	if w.lines {
+		suffix = "\n"
+	}
+	_, err = fmt.Fprintf(w.out, "%s%s%s", prefix, bytes, suffix)
+	return err
+}
+
+func (w *jsonResultWriter) Close() error {
+	if w.lines {
+		return nil
+	}
+	if w.first { // no results
+		_, err := fmt.Fprint(w.out, "[")
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(w.out, "\n]\n")
+	return err
 }
 
 func (opt *SQLOption) Run() error {
 	maskFunc := globalOption.ResolveMaskFunc()
+	err := globalOption.ApplySecret()
+	if err != nil {
+		return err
+	}
+	err = globalOption.ApplySkipList()
+	if err != nil {
+		return err
+	}
+
+	paths := opt.Inputs
+	if opt.File != "" {
+		paths = append([]string{opt.File}, paths...)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no SQL file given, use `-` to read from stdin")
+	}
+	if opt.Format == "" {
+		for _, path := range paths {
+			if path == stdinPath {
+				// work as a filter for pipelines
+				opt.Format = "sql"
+			}
+		}
+	}
+
+	var outFile io.Writer = os.Stdout
+	if opt.Output != "" {
+		file, err := os.Create(opt.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		outFile = file
+	}
+	out := bufio.NewWriter(outFile)
+	defer out.Flush()
+
+	writer, err := newSQLResultWriter(opt.Format, out)
+	if err != nil {
+		return err
+	}
 
 	db, err := NewPreparedTiDBContext()
 	if err != nil {
@@ -20,24 +178,40 @@ func (opt *SQLOption) Run() error {
 	}
 
 	nameMap := globalOption.ReadNameMap()
-	masker := mask.NewSQLWorker(db, maskFunc, globalOption.IgnoreIntPK, nameMap)
+	policy, err := globalOption.ReadMaskPolicy()
+	if err != nil {
+		return err
+	}
+	masker := mask.NewSQLWorker(db, maskFunc, policy, globalOption.IgnoreIntPK, nameMap)
+	masker.ShowValues = opt.ShowValues
 
-	maskSQLs := make(chan string)
-	go ReadSQLs(maskSQLs, opt.File)
+	maskSQLs, readErr := readSQLsInBackground(paths...)
 	for sql := range maskSQLs {
-		fmt.Printf("\n-> %s\n", sql)
-		newSQL, err := masker.MaskOne(sql)
+		result := masker.MaskOneResult(sql)
+		err := writer.Write(result)
 		if err != nil {
-			if newSQL != "" { // problematic
-				color.Yellow("?> %v\n", err)
-			} else {
-				color.Red("!> %v\n", err)
-				continue
-			}
+			return err
 		}
-		fmt.Printf("=> %s\n", newSQL)
+	}
+	err = <-readErr
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	err = out.Flush()
+	if err != nil {
+		return err
 	}
 
-	masker.Stats.PrintSummary()
+	if _, ok := writer.(*textResultWriter); ok && opt.Output == "" {
+		masker.Stats.PrintSummary()
+	} else {
+		// keep the output clean for pipelines
+		zap.S().Infow("mask done", "stats", masker.Stats.String())
+	}
+	masker.Stats.PrintUnmapped(os.Stderr)
 	return nil
 }
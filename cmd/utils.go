@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bufio"
+	"fmt"
 	"io"
 	"os"
-	"strings"
 
 	"github.com/BugenZhao/sql-masker/mask"
 	"github.com/BugenZhao/sql-masker/tidb"
@@ -30,28 +29,50 @@ type TaskResult struct {
 	err   error
 }
 
-// Read SQL files into statements and output to `out` chan
-func ReadSQLs(out chan<- string, sqlPaths ...string) {
+// Path for reading SQLs from stdin
+const stdinPath = "-"
+
+// Read SQL files into statements and output to `out` chan, `-` stands for stdin.
+// Files are read in a streaming manner, so memory usage is bounded by the size of a single statement.
+func ReadSQLs(out chan<- string, sqlPaths ...string) error {
 	defer close(out)
 
 	for _, path := range sqlPaths {
-		file, err := os.Open(path)
-		if err != nil {
-			panic(err)
+		var file *os.File
+		if path == stdinPath {
+			file = os.Stdin
+		} else {
+			var err error
+			file, err = os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
 		}
-		defer file.Close()
 
-		reader := bufio.NewReader(file)
+		reader := tidb.NewStatementReader(file)
 		for {
-			sql, err := reader.ReadString(';')
+			sql, err := reader.Next()
 			if err == io.EOF {
 				break
 			} else if err != nil {
-				return
+				return fmt.Errorf("failed to read sqls from `%s`; %w", path, err)
 			}
-			out <- strings.TrimSpace(sql)
+			out <- sql
 		}
 	}
+	return nil
+}
+
+// Run `ReadSQLs` in background, returns the chan of statements and the chan of the error,
+// which is sent after all statements
+func readSQLsInBackground(sqlPaths ...string) (<-chan string, <-chan error) {
+	sqls := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ReadSQLs(sqls, sqlPaths...)
+	}()
+	return sqls, errc
 }
 
 var (
@@ -8,10 +8,24 @@ import (
 	"github.com/zeebo/blake3"
 )
 
-
 func NewDictionary(context string, prefix string) *Dictionary {
+	return newDictionary(blake3.NewDeriveKey(context), prefix)
+}
+
+// Create a dictionary keyed by `secret`, whose mapped names can't be reversed without it
+func NewKeyedDictionary(context string, secret []byte, prefix string) (*Dictionary, error) {
+	key := make([]byte, 32)
+	blake3.DeriveKey(context, secret, key)
+	hasher, err := blake3.NewKeyed(key)
+	if err != nil {
+		return nil, err
+	}
+	return newDictionary(hasher, prefix), nil
+}
+
+func newDictionary(hasher *blake3.Hasher, prefix string) *Dictionary {
 	return &Dictionary{
-		hasher: blake3.NewDeriveKey(context),
+		hasher: hasher,
 		prefix: prefix,
 		dict:   make(map[string]uint32),
 		values: make(map[uint32]bool),
@@ -0,0 +1,19 @@
+CREATE TABLE `lineitem` (
+  `l_orderkey` bigint(20) NOT NULL,
+  `l_partkey` bigint(20) NOT NULL,
+  `l_suppkey` bigint(20) NOT NULL,
+  `l_linenumber` bigint(20) NOT NULL,
+  `l_quantity` decimal(15,2) NOT NULL,
+  `l_extendedprice` decimal(15,2) NOT NULL,
+  `l_discount` decimal(15,2) NOT NULL,
+  `l_tax` decimal(15,2) NOT NULL,
+  `l_returnflag` char(1) NOT NULL,
+  `l_linestatus` char(1) NOT NULL,
+  `l_shipdate` date NOT NULL,
+  `l_commitdate` date NOT NULL,
+  `l_receiptdate` date NOT NULL,
+  `l_shipinstruct` char(25) NOT NULL,
+  `l_shipmode` char(10) NOT NULL,
+  `l_comment` varchar(44) NOT NULL,
+  PRIMARY KEY (`l_orderkey`,`l_linenumber`) /*T![clustered_index] NONCLUSTERED */
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,7 @@
+CREATE TABLE `nation` (
+  `n_nationkey` bigint(20) NOT NULL,
+  `n_name` char(25) NOT NULL,
+  `n_regionkey` bigint(20) NOT NULL,
+  `n_comment` varchar(152) DEFAULT NULL,
+  PRIMARY KEY (`n_nationkey`) /*T![clustered_index] CLUSTERED */
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,12 @@
+CREATE TABLE `orders` (
+  `o_orderkey` bigint(20) NOT NULL,
+  `o_custkey` bigint(20) NOT NULL,
+  `o_orderstatus` char(1) NOT NULL,
+  `o_totalprice` decimal(15,2) NOT NULL,
+  `o_orderdate` date NOT NULL,
+  `o_orderpriority` char(15) NOT NULL,
+  `o_clerk` char(15) NOT NULL,
+  `o_shippriority` bigint(20) NOT NULL,
+  `o_comment` varchar(79) NOT NULL,
+  PRIMARY KEY (`o_orderkey`) /*T![clustered_index] CLUSTERED */
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,8 @@
+CREATE TABLE `partsupp` (
+  `ps_partkey` bigint(20) NOT NULL,
+  `ps_suppkey` bigint(20) NOT NULL,
+  `ps_availqty` bigint(20) NOT NULL,
+  `ps_supplycost` decimal(15,2) NOT NULL,
+  `ps_comment` varchar(199) NOT NULL,
+  PRIMARY KEY (`ps_partkey`,`ps_suppkey`) /*T![clustered_index] NONCLUSTERED */
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,6 @@
+CREATE TABLE `region` (
+  `r_regionkey` bigint(20) NOT NULL,
+  `r_name` char(25) NOT NULL,
+  `r_comment` varchar(152) DEFAULT NULL,
+  PRIMARY KEY (`r_regionkey`) /*T![clustered_index] CLUSTERED */
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
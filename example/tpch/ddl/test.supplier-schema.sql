@@ -0,0 +1,10 @@
+CREATE TABLE `supplier` (
+  `s_suppkey` bigint(20) NOT NULL,
+  `s_name` char(25) NOT NULL,
+  `s_address` varchar(40) NOT NULL,
+  `s_nationkey` bigint(20) NOT NULL,
+  `s_phone` char(15) NOT NULL,
+  `s_acctbal` decimal(15,2) NOT NULL,
+  `s_comment` varchar(101) NOT NULL,
+  PRIMARY KEY (`s_suppkey`) /*T![clustered_index] CLUSTERED */
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
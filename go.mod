@@ -10,6 +10,7 @@ require (
 	github.com/pingcap/tidb v1.1.0-beta.0.20211011083326-e8f4e47798d2
 	github.com/zyguan/mysql-replay v0.0.0-20211008084918-01715661643b
 	go.uber.org/zap v1.19.1
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -140,7 +141,6 @@ require (
 	google.golang.org/grpc v1.40.0 // indirect
 	google.golang.org/protobuf v1.27.1 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 	modernc.org/mathutil v1.4.0 // indirect
 	sigs.k8s.io/yaml v1.1.0 // indirect
@@ -1,18 +1,30 @@
 package mask
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
+	"github.com/BugenZhao/sql-masker/mask/funcs"
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
-	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/parser/opcode"
+	"github.com/pingcap/tidb/expression"
 	"github.com/pingcap/tidb/types"
 	driver "github.com/pingcap/tidb/types/parser_driver"
 )
 
 type InferredType struct {
 	Ft *types.FieldType
+	// The column which this type is inferred from, nil if unknown
+	Column *expression.Column
+	// All columns that the constant is compared with or assigned to during inference
+	SourceColumns []*expression.Column
+	// All candidate types ranked, if they are of different eval types, nil if not ambiguous
+	Candidates []*InferredType
 }
 
 func NewIntHandleInferredType() *InferredType {
@@ -23,7 +35,14 @@ func NewIntHandleInferredType() *InferredType {
 
 func NewInferredType(ft *types.FieldType) *InferredType {
 	return &InferredType{
-		ft,
+		Ft: ft,
+	}
+}
+
+func NewColumnInferredType(col *expression.Column) *InferredType {
+	return &InferredType{
+		Ft:     col.GetType(),
+		Column: col,
 	}
 }
 
@@ -31,10 +50,78 @@ func (it InferredType) IsPrimaryKey() bool {
 	return mysql.HasPriKeyFlag(it.Ft.Flag)
 }
 
+// Original name of the column like `db.table.col` in lower case, empty if unknown
+func (it InferredType) ColumnName() string {
+	if it.Column == nil {
+		return ""
+	}
+	return strings.ToLower(it.Column.OrigName)
+}
+
+// Original names of all source columns in lower case
+func (it InferredType) SourceColumnNames() []string {
+	names := make([]string, 0, len(it.SourceColumns))
+	for _, col := range it.SourceColumns {
+		names = append(names, strings.ToLower(col.OrigName))
+	}
+	return names
+}
+
+// Whether the constant may be of several different types
+func (it InferredType) IsAmbiguous() bool {
+	return len(it.Candidates) > 1
+}
+
+// Candidate types like "int(11) of `db.table.col`", nil if not ambiguous
+func (it InferredType) CandidateNames() []string {
+	if !it.IsAmbiguous() {
+		return nil
+	}
+	names := make([]string, 0, len(it.Candidates))
+	for _, c := range it.Candidates {
+		tp := strings.Split(c.Ft.String(), " ")[0]
+		if c.Column != nil {
+			tp = fmt.Sprintf("%s of `%s`", tp, c.ColumnName())
+		}
+		names = append(names, tp)
+	}
+	return names
+}
+
+func (it *InferredType) candidates() []*InferredType {
+	if it.IsAmbiguous() {
+		return it.Candidates
+	}
+	return []*InferredType{it}
+}
+
+// Identity of a candidate type, for deduplicating and ordering
+func (it InferredType) key() string {
+	return it.ColumnName() + " " + it.Ft.String()
+}
+
+func (it InferredType) String() string {
+	tp := strings.Split(it.Ft.String(), " ")[0]
+	if names := it.SourceColumnNames(); len(names) > 0 {
+		return fmt.Sprintf("%s against `%s`", tp, strings.Join(names, "`, `"))
+	}
+	return tp
+}
+
 type ReplaceMarker int64
 type ExprMap = map[ReplaceMarker]*driver.ValueExpr
 type ExprOffsetMap = map[ReplaceMarker]int
 type TypeMap = map[ReplaceMarker]*InferredType
+type GroupMap = map[ReplaceMarker]*MarkerGroup
+
+// A subtree of constants only, like `-1`, `1 + 2`, `CAST(1 AS CHAR)` or `DATE '2021-01-01'`,
+// which would be folded into a new constant by the planner and lose identities of markers in it.
+// So the subtree is replaced with a single marker as a whole, and all markers in it share the
+// inferred type.
+type MarkerGroup struct {
+	Expr    ast.ExprNode
+	Markers []ReplaceMarker
+}
 
 type ReplaceMode int
 
@@ -49,9 +136,57 @@ func (m ReplaceMarker) IntValue() int64 {
 	return int64(m)
 }
 
+func (m ReplaceMarker) valid() bool {
+	return m > replaceMarkerStep && m%replaceMarkerStep == 1
+}
+
+// Find the marker that a constant in the plan stands for. The planner may convert the type of
+// a marker against the other side, like `1001` to `1001.00` or `"1001"`, but never its value.
+//
+// Markers folded with other constants are kept by `MarkerGroup`s, but a marker implicitly cast
+// by the planner into a type not keeping its value is still lost, like `1001` assigned to a
+// `DATETIME` column, which becomes `2000-10-01`. Such constants are reported as not inferred and
+// masked with their own types.
+func markerOf(datum types.Datum) (ReplaceMarker, bool) {
+	var m ReplaceMarker
+	switch datum.Kind() {
+	case types.KindInt64:
+		m = ReplaceMarker(datum.GetInt64())
+	case types.KindUint64:
+		m = ReplaceMarker(datum.GetUint64())
+	default:
+		s, err := datum.ToString()
+		if err != nil {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f != math.Trunc(f) {
+			return 0, false
+		}
+		m = ReplaceMarker(f)
+	}
+	return m, m.valid()
+}
+
+// Share the inferred type of each group with markers in it
+func expandGroupTypes(groups GroupMap, inferredTypes TypeMap) {
+	for g, group := range groups {
+		tp, ok := inferredTypes[g]
+		if !ok {
+			continue
+		}
+		for _, m := range group.Markers {
+			if _, ok := inferredTypes[m]; !ok {
+				inferredTypes[m] = tp
+			}
+		}
+	}
+}
+
 // Check whether a node is `COUNT(1)`
 func isCountOne(in *ast.AggregateFuncExpr) bool {
-	if in.F == ast.AggFuncCount && len(in.Args) == 1 {
+	// the name is in its original case
+	if strings.EqualFold(in.F, ast.AggFuncCount) && len(in.Args) == 1 {
 		arg := in.Args[0]
 		if expr, ok := arg.(*driver.ValueExpr); ok {
 			return expr.Datum.GetInt64() == 1 && expr.Datum.Kind() == types.KindInt64
@@ -60,29 +195,199 @@ func isCountOne(in *ast.AggregateFuncExpr) bool {
 	return false
 }
 
-// Constants in some clauses should not be considered to be masked, like `LIMIT 100`
-// or `SELECT COUNT(1)` (which is rewritten from `COUNT(*)`)
-func enterMayIgnoreSubtree(in ast.Node) (node ast.Node, skipChilren bool) {
+// A rule of structural literals, which are not user data and never masked, since masking them
+// makes statements fail or behave completely differently
+type SkipRule struct {
+	Name        string
+	Description string
+	// nodes to skip, which are `in` itself or some of its children
+	skip func(l *SkipList, in ast.Node) []ast.Node
+}
+
+// All rules, which are enabled by default. Note that positions like `ORDER BY 1` are parsed as
+// `PositionExpr` instead of literals, so they are never masked.
+var SkipRules = []SkipRule{
+	{"limit", "`LIMIT 10, 20`", func(l *SkipList, in ast.Node) []ast.Node {
+		if in, ok := in.(*ast.Limit); ok {
+			return []ast.Node{in}
+		}
+		return nil
+	}},
+	{"rows-frame", "`ROWS 2 PRECEDING` in window frames", func(l *SkipList, in ast.Node) []ast.Node {
+		if in, ok := in.(*ast.FrameClause); ok && in.Type == ast.Rows {
+			return []ast.Node{in}
+		}
+		return nil
+	}},
+	{"count-one", "`COUNT(1)`, which is also rewritten from `COUNT(*)`", func(l *SkipList, in ast.Node) []ast.Node {
+		if in, ok := in.(*ast.AggregateFuncExpr); ok && isCountOne(in) {
+			return []ast.Node{in}
+		}
+		return nil
+	}},
+	{"separator", "the separator of `GROUP_CONCAT(... SEPARATOR ',')`", func(l *SkipList, in ast.Node) []ast.Node {
+		if in, ok := in.(*ast.AggregateFuncExpr); ok && strings.EqualFold(in.F, ast.AggFuncGroupConcat) && len(in.Args) > 0 {
+			return []ast.Node{in.Args[len(in.Args)-1]}
+		}
+		return nil
+	}},
+	{"func-args", "structural arguments of functions, like JSON paths, intervals, formats, positions and charsets", func(l *SkipList, in ast.Node) []ast.Node {
+		fn, ok := in.(*ast.FuncCallExpr)
+		if !ok {
+			return nil
+		}
+		skipped := []ast.Node{}
+		for _, arg := range argsOfRole(fn.FnName.L, fn.Args, argStructural) {
+			skipped = append(skipped, arg)
+		}
+		for _, i := range l.args[fn.FnName.L] {
+			if i < len(fn.Args) {
+				skipped = append(skipped, fn.Args[i])
+			}
+		}
+		return skipped
+	}},
+}
+
+// A configurable skip-list of structural literals, see `SkipRules`
+type SkipList struct {
+	rules []SkipRule
+	// extra structural arguments of functions by names in lower case, with 0-based positions
+	args map[string][]int
+}
+
+// Create a skip-list with rules named `rules`, or all rules if empty. `args` are extra
+// structural arguments of functions like `json_set:2,4`, with 1-based positions.
+func NewSkipList(rules []string, args []string) (*SkipList, error) {
+	l := &SkipList{args: make(map[string][]int)}
+	if len(rules) == 0 {
+		l.rules = SkipRules
+	}
+	for _, name := range rules {
+		found := false
+		for _, rule := range SkipRules {
+			if rule.Name == strings.ToLower(name) {
+				l.rules = append(l.rules, rule)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no such skip rule `%s`", name)
+		}
+	}
+
+	for _, arg := range args {
+		tokens := strings.SplitN(arg, ":", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("bad structural arguments `%s`, should be like `json_set:2,4`", arg)
+		}
+		fn := strings.ToLower(tokens[0])
+		for _, pos := range strings.Split(tokens[1], ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(pos))
+			if err != nil || i < 1 {
+				return nil, fmt.Errorf("bad position `%s` in structural arguments `%s`", pos, arg)
+			}
+			l.args[fn] = append(l.args[fn], i-1)
+		}
+	}
+	return l, nil
+}
+
+var skipList, _ = NewSkipList(nil, nil)
+
+// Set the skip-list used by all workers
+func SetSkipList(l *SkipList) {
+	skipList = l
+}
+
+// Nodes to skip in `in` and its children
+func (l *SkipList) skipped(in ast.Node) []ast.Node {
+	skipped := []ast.Node{}
+	for _, rule := range l.rules {
+		skipped = append(skipped, rule.skip(l, in)...)
+	}
+	return skipped
+}
+
+// Tracks whether an AST visitor is in a subtree of structural literals, where constants are kept
+// as is. Names are still visited in such subtrees, like `SUBSTRING(c, 1, LENGTH(d))`.
+type structuralTracker struct {
+	// the skip-list, or the global one if nil
+	list  *SkipList
+	nodes map[ast.Node]bool
+	depth int
+}
+
+func (t *structuralTracker) enter(in ast.Node) {
+	list := t.list
+	if list == nil {
+		list = skipList
+	}
+	for _, node := range list.skipped(in) {
+		if t.nodes == nil {
+			t.nodes = make(map[ast.Node]bool)
+		}
+		t.nodes[node] = true
+	}
+	if t.nodes[in] {
+		t.depth += 1
+	}
+}
+
+func (t *structuralTracker) leave(in ast.Node) {
+	if t.nodes[in] {
+		t.depth -= 1
+		delete(t.nodes, in)
+	}
+}
+
+func (t *structuralTracker) inside() bool {
+	return t.depth > 0
+}
+
+// A constant used as the pattern of `LIKE`, `REGEXP` or `RLIKE`, whose literal segments are masked
+// while wildcards, escapes and anchors are kept
+type Pattern struct {
+	Syntax funcs.PatternSyntax
+	// the escape character of `LIKE`
+	Escape rune
+}
+
+// Tracks patterns of the visited pattern expressions
+type patternTracker map[ast.Node]Pattern
+
+func (t patternTracker) enter(in ast.Node) {
 	switch in := in.(type) {
-	case *ast.Limit:
-		return in, true
-	case *ast.AggregateFuncExpr:
-		return in, isCountOne(in)
-	default:
-		return in, false
+	case *ast.PatternLikeExpr:
+		t[in.Pattern] = Pattern{funcs.PatternLike, rune(in.Escape)}
+	case *ast.PatternRegexpExpr:
+		t[in.Pattern] = Pattern{funcs.PatternRegexp, 0}
 	}
 }
 
+// The pattern context of `in`, and remove it since it's left
+func (t patternTracker) leave(in ast.Node) (Pattern, bool) {
+	pattern, ok := t[in]
+	delete(t, in)
+	return pattern, ok
+}
+
 func NewReplaceVisitor(mode ReplaceMode) *ReplaceVisitor {
 	return &ReplaceVisitor{
 		mode:        mode,
 		next:        replaceMarkerStep + 1,
 		OriginExprs: make(ExprMap),
 		Offsets:     make(ExprOffsetMap),
+		Groups:      make(GroupMap),
+		Structural:  make(map[ReplaceMarker]bool),
+		Patterns:    make(map[ReplaceMarker]Pattern),
+		constants:   make(map[ast.ExprNode][]ReplaceMarker),
+		patterns:    make(patternTracker),
 	}
 }
 
-// Replace constants with disjoint numbers as `ReplaceMarker`s.
+// Replace constants with disjoint numbers as `ReplaceMarker`s, and subtrees of constants only
+// with `MarkerGroup`s.
 //
 // For normal statements, replace values with `Value` mode.
 // For handling `PREPARE` statements internally, we may replace values with `ParamMarker` mode.
@@ -91,6 +396,15 @@ type ReplaceVisitor struct {
 	next        ReplaceMarker
 	OriginExprs ExprMap
 	Offsets     ExprOffsetMap
+	Groups      GroupMap
+	// markers of params in structural arguments of functions, which are never masked
+	Structural map[ReplaceMarker]bool
+	// markers of params used as patterns, like `LIKE ?`
+	Patterns map[ReplaceMarker]Pattern
+	// replaced nodes of constants, with markers in them
+	constants  map[ast.ExprNode][]ReplaceMarker
+	structural structuralTracker
+	patterns   patternTracker
 }
 
 func (v *ReplaceVisitor) nextMarker() ReplaceMarker {
@@ -100,16 +414,84 @@ func (v *ReplaceVisitor) nextMarker() ReplaceMarker {
 }
 
 func (v *ReplaceVisitor) Enter(in ast.Node) (node ast.Node, skipChilren bool) {
-	return enterMayIgnoreSubtree(in)
+	v.structural.enter(in)
+	v.patterns.enter(in)
+	return in, false
+}
+
+// Operands of a node that will be folded by the planner if all of them are constants
+func foldableOperands(in ast.Node) ([]ast.ExprNode, bool) {
+	switch in := in.(type) {
+	case *ast.ParenthesesExpr:
+		return []ast.ExprNode{in.Expr}, true
+	case *ast.UnaryOperationExpr:
+		switch in.Op {
+		case opcode.Minus, opcode.Plus, opcode.BitNeg:
+			return []ast.ExprNode{in.V}, true
+		}
+	case *ast.BinaryOperationExpr:
+		switch in.Op {
+		case opcode.Plus, opcode.Minus, opcode.Mul, opcode.Div, opcode.IntDiv, opcode.Mod:
+			return []ast.ExprNode{in.L, in.R}, true
+		}
+	case *ast.FuncCastExpr:
+		return []ast.ExprNode{in.Expr}, true
+	case *ast.FuncCallExpr:
+		switch in.FnName.L {
+		case ast.DateLiteral, ast.TimeLiteral, ast.TimestampLiteral:
+			return in.Args, true
+		}
+		// like `DATE_ADD('2021-01-01', INTERVAL 1 DAY)`, whose result is of the type of its data
+		// argument, so that the data argument is masked with the type inferred for the result
+		if sig, ok := funcSignatures[in.FnName.L]; ok && sig.passThrough && len(argsOfRole(in.FnName.L, in.Args, argOther)) == 0 {
+			return in.Args, true
+		}
+	}
+	return nil, false
+}
+
+// Replace `in` with a `MarkerGroup` if all operands are replaced constants
+func (v *ReplaceVisitor) mayGroup(in ast.ExprNode, operands []ast.ExprNode) ast.ExprNode {
+	markers := []ReplaceMarker{}
+	for _, operand := range operands {
+		operandMarkers, ok := v.constants[operand]
+		if !ok {
+			return in
+		}
+		markers = append(markers, operandMarkers...)
+	}
+	if len(markers) == 0 {
+		// constants not to be masked, like those in `PREPARE` statements
+		v.constants[in] = markers
+		return in
+	}
+
+	n := v.nextMarker()
+	replacedExpr := ast.NewValueExpr(n.IntValue(), "", "")
+	v.Groups[n] = &MarkerGroup{Expr: in, Markers: markers}
+	v.constants[replacedExpr] = markers
+	return replacedExpr
 }
 
 func (v *ReplaceVisitor) Leave(in ast.Node) (node ast.Node, ok bool) {
+	structural := v.structural.inside()
+	v.structural.leave(in)
+	pattern, isPattern := v.patterns.leave(in)
+
+	if operands, ok := foldableOperands(in); ok {
+		return v.mayGroup(in.(ast.ExprNode), operands), true
+	}
+	if structural {
+		return v.leaveStructural(in), true
+	}
+
 	switch v.mode {
 	case ReplaceModeValue:
 		if expr, ok := in.(*driver.ValueExpr); ok {
 			n := v.nextMarker()
 			replacedExpr := ast.NewValueExpr(n.IntValue(), "", "")
 			v.OriginExprs[n] = expr
+			v.constants[replacedExpr] = []ReplaceMarker{n}
 			return replacedExpr, true
 		}
 	case ReplaceModeParamMarker:
@@ -117,12 +499,17 @@ func (v *ReplaceVisitor) Leave(in ast.Node) (node ast.Node, ok bool) {
 			n := v.nextMarker()
 			replacedExpr := ast.NewValueExpr(n.IntValue(), "", "")
 			v.Offsets[n] = expr.Offset
+			if isPattern {
+				v.Patterns[n] = pattern
+			}
+			v.constants[replacedExpr] = []ReplaceMarker{n}
 			return replacedExpr, true
 		}
 		if _, ok := in.(*driver.ValueExpr); ok {
-			// HACK: replace all constants with `1` for better inference even after plan rewriting
-			//       this is ok since we do not restore `PREPARE` statements
+			// other constants in `PREPARE` statements are not masked, replace them with `1` so
+			// that they are never taken as markers, this is ok since we do not restore them
 			replacedExpr := ast.NewValueExpr(1, "", "")
+			v.constants[replacedExpr] = nil
 			return replacedExpr, true
 		}
 	}
@@ -130,6 +517,25 @@ func (v *ReplaceVisitor) Leave(in ast.Node) (node ast.Node, ok bool) {
 	return in, true
 }
 
+// Constants in structural arguments are kept as is, except params which are replaced with
+// markers as usual for matching params in `EXECUTE`
+func (v *ReplaceVisitor) leaveStructural(in ast.Node) ast.Node {
+	switch in := in.(type) {
+	case *driver.ValueExpr, *ast.TimeUnitExpr:
+		v.constants[in.(ast.ExprNode)] = nil
+	case *driver.ParamMarkerExpr:
+		if v.mode == ReplaceModeParamMarker {
+			n := v.nextMarker()
+			replacedExpr := ast.NewValueExpr(n.IntValue(), "", "")
+			v.Offsets[n] = in.Offset
+			v.Structural[n] = true
+			v.constants[replacedExpr] = nil
+			return replacedExpr
+		}
+	}
+	return in
+}
+
 type RestoreMode int
 
 const (
@@ -138,34 +544,29 @@ const (
 )
 
 // Create a `RestoreVisitor` with mode `NameValue`
-func NewRestoreVisitor(originExprs ExprMap, inferredTypes TypeMap, maskFunc MaskFunc, nameMap *NameMap, ignoreIntPK bool) *RestoreVisitor {
-	sc := stmtctx.StatementContext{}
-	sc.IgnoreTruncate = true // todo: what's this ?
-
+func NewRestoreVisitor(originExprs ExprMap, groups GroupMap, inferredTypes TypeMap, maskFunc MaskFunc, policy *MaskPolicy, nameMap *NameMap, ignoreIntPK bool) *RestoreVisitor {
 	return &RestoreVisitor{
+		valueMasker:   newValueMasker(maskFunc, policy, ignoreIntPK),
 		mode:          RestoreModeNameValue,
 		originExprs:   originExprs,
+		groups:        groups,
 		inferredTypes: inferredTypes,
-		stmtContext:   &sc,
-		maskFunc:      maskFunc,
 		nameMap:       nameMap,
-		ignoreIntPK:   ignoreIntPK,
 		success:       0,
-		err:           nil,
+		errs:          nil,
+		patterns:      make(patternTracker),
 	}
 }
 
 // Create a `RestoreVisitor` with mode `NameOnly`, which ignores restoring of constant values
 func NewNameOnlyRestoreVisitor(nameMap *NameMap) *RestoreVisitor {
-	sc := stmtctx.StatementContext{}
-	sc.IgnoreTruncate = true // todo: what's this ?
-
 	return &RestoreVisitor{
+		valueMasker: newValueMasker(MaskFunc{}, nil, false),
 		mode:        RestoreModeNameOnly,
-		stmtContext: &sc,
 		nameMap:     nameMap,
 		success:     0,
-		err:         nil,
+		errs:        nil,
+		patterns:    make(patternTracker),
 	}
 }
 
@@ -173,36 +574,105 @@ func NewNameOnlyRestoreVisitor(nameMap *NameMap) *RestoreVisitor {
 // infer types of them and construct a `TypeMap`.
 //
 // `RestoreVisitor` will traverse the AST, restoring and masking constants based on information
-// from `ExprMap` and `TypeMap`. Also in restore phase, names may need to be masked if `nameMap`
+// from `ExprMap` and `TypeMap`, and subtrees of `MarkerGroup`s, with the mask function chosen by `policy` for the inferred column,
+// or `maskFunc` if not given. Also in restore phase, names may need to be masked if `nameMap`
 // is given.
 //
 // Note that for `?` in PREPARE statements, there's neither way nor need to restore them, so an
 // option of `RestoreMode` with two variants `NameValue` and `NameOnly` is provided.
 type RestoreVisitor struct {
+	valueMasker
 	mode          RestoreMode
 	originExprs   ExprMap
+	groups        GroupMap
 	inferredTypes TypeMap
-	stmtContext   *stmtctx.StatementContext
-	maskFunc      MaskFunc
 	nameMap       *NameMap
-	ignoreIntPK   bool
 	success       int
-	err           error
+	errs          MultiError
+	structural    structuralTracker
+	patterns      patternTracker
+	declared      bool // whether aliases in the statement are declared to `nameMap`
+}
+
+// Errors of several constants in a statement
+type MultiError []error
+
+func (e MultiError) Error() string {
+	msgs := ErrorMessages(e)
+	return strings.Join(msgs, "; ")
+}
+
+// Split `err` into messages if it's a `MultiError`
+func ErrorMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+	var multi MultiError
+	if !errors.As(err, &multi) {
+		return []string{err.Error()}
+	}
+	msgs := make([]string, 0, len(multi))
+	for _, e := range multi {
+		msgs = append(msgs, e.Error())
+	}
+	return msgs
+}
+
+// A constant masked under a guessed type, since it may be of several types. The constant is
+// referred to by its position, since the message is written along with the masked output.
+type AmbiguousTypeError struct {
+	Position int // 1-based position among constants in the statement
+	Type     *InferredType
+}
+
+func (e *AmbiguousTypeError) Error() string {
+	return fmt.Sprintf("type for constant %d is ambiguous among %s", e.Position, strings.Join(e.Type.CandidateNames(), ", "))
+}
+
+// 1-based position of the constant of `m` among all constants in `originExprs`, which is also
+// its index in `sortedInferredConstants`
+func constantPosition(originExprs ExprMap, m ReplaceMarker) int {
+	position := 0
+	for other := range originExprs {
+		if other <= m {
+			position += 1
+		}
+	}
+	return position
 }
 
 func (v *RestoreVisitor) appendError(err error) {
-	if v.err == nil {
-		v.err = err
-	} else {
-		v.err = fmt.Errorf("%w; %v", v.err, err)
+	v.errs = append(v.errs, err)
+}
+
+// All errors during restoring, nil if none
+func (v *RestoreVisitor) Err() error {
+	if len(v.errs) == 0 {
+		return nil
 	}
+	return v.errs
 }
 
 func (v *RestoreVisitor) Enter(in ast.Node) (_ ast.Node, skipChilren bool) {
-	return enterMayIgnoreSubtree(in)
+	v.structural.enter(in)
+	v.patterns.enter(in)
+	if v.nameMap != nil {
+		if !v.declared {
+			// aliases may be referred to before declared
+			v.nameMap.declareAliases(in)
+			v.declared = true
+		}
+		// objects of tables are mapped with original names of tables
+		v.nameMap.tableObjects(in)
+	}
+	return in, false
 }
 
 func (v *RestoreVisitor) Leave(in ast.Node) (_ ast.Node, ok bool) {
+	structural := v.structural.inside()
+	v.structural.leave(in)
+	pattern, isPattern := v.patterns.leave(in)
+
 	// mask names
 	if v.nameMap != nil {
 		if col, ok := in.(*ast.ColumnName); ok {
@@ -229,9 +699,42 @@ func (v *RestoreVisitor) Leave(in ast.Node) (_ ast.Node, ok bool) {
 			tab = v.nameMap.TableName(tab)
 			return tab, true
 		}
+		if variable, ok := in.(*ast.VariableExpr); ok && !variable.IsSystem {
+			variable.Name = v.nameMap.Variable(variable.Name)
+		}
+		if assignment, ok := in.(*ast.VariableAssignment); ok && isUserVariable(assignment) {
+			assignment.Name = v.nameMap.Variable(assignment.Name)
+		}
+		if call, ok := in.(*ast.CallStmt); ok {
+			from := call.Procedure.FnName.O
+			if call.Procedure.Schema.L != "" {
+				from = fmt.Sprintf("%s.%s", call.Procedure.Schema.O, from)
+			}
+			tokens := strings.Split(v.nameMap.Routine(from), ".")
+			call.Procedure.FnName = model.NewCIStr(tokens[len(tokens)-1])
+			if len(tokens) == 2 {
+				call.Procedure.Schema = model.NewCIStr(tokens[0])
+			}
+			return call, true
+		}
 		if hint, ok := in.(*ast.TableOptimizerHint); ok {
+			hintTableName := func(table ast.HintTable) string {
+				if table.DBName.L == "" {
+					return table.TableName.L
+				}
+				return fmt.Sprintf("%s.%s", table.DBName.L, table.TableName.L)
+			}
+			if len(hint.Tables) > 0 {
+				// like `USE_INDEX(t idx)`
+				for i := range hint.Indexes {
+					hint.Indexes[i] = model.NewCIStr(v.nameMap.Index(hintTableName(hint.Tables[0]), hint.Indexes[i].O))
+				}
+			}
 			newHintTables := []ast.HintTable{}
 			for _, table := range hint.Tables {
+				for i := range table.PartitionList {
+					table.PartitionList[i] = model.NewCIStr(v.nameMap.Partition(hintTableName(table), table.PartitionList[i].O))
+				}
 				table.DBName = model.NewCIStr(v.nameMap.DB(table.DBName.L))
 				table.TableName = model.NewCIStr(v.nameMap.table(table.TableName.L))
 				newHintTables = append(newHintTables, table)
@@ -240,13 +743,16 @@ func (v *RestoreVisitor) Leave(in ast.Node) (_ ast.Node, ok bool) {
 			return hint, true
 		}
 	}
-	if v.mode == RestoreModeNameOnly {
+	if v.mode == RestoreModeNameOnly || structural {
 		return in, true
 	}
 
 	// mask values
 	if expr, ok := in.(*driver.ValueExpr); ok {
 		m := ReplaceMarker(expr.Datum.GetInt64())
+		if group, ok := v.groups[m]; ok {
+			return group.Expr.Accept(v)
+		}
 		originExpr, ok := v.originExprs[m]
 		if !ok {
 			v.appendError(fmt.Errorf("no replace record found for `%v`", expr.Datum))
@@ -254,33 +760,31 @@ func (v *RestoreVisitor) Leave(in ast.Node) (_ ast.Node, ok bool) {
 		}
 		inferredType, ok := v.inferredTypes[m]
 		if !ok {
-			// // DIRTY HACK: handle `a + b`
-			// guessI := m*2 + replaceMarkerStep
-			// guessedType, ok := v.inferredTypes[guessI]
-			// if ok {
-			// 	v.appendError(fmt.Errorf("type for `%v` is guessed", originExpr.Datum))
-			// 	inferredType = guessedType
-			// } else {
-			v.appendError(fmt.Errorf("type for `%v` not inferred", originExpr.Datum))
-			return originExpr, true
-			// }
+			v.appendError(fmt.Errorf("type for constant %d not inferred", constantPosition(v.originExprs, m)))
+			// never keep the original value, see `markerOf`
+			inferredType = ownInferredType(originExpr.Datum)
 		}
 
 		var maskedDatum types.Datum
 		var maskedType *types.FieldType
+		var masked bool
 		var err error
-
-		if inferredType.IsPrimaryKey() && v.ignoreIntPK {
-			// use original datum if int pk is ignored
-			maskedDatum, maskedType = originExpr.Datum, &originExpr.Type
+		if isPattern {
+			maskedDatum, maskedType, masked, err = v.maskPattern(originExpr.Datum, inferredType, pattern)
 		} else {
-			maskedDatum, maskedType, err = ConvertAndMask(v.stmtContext, originExpr.Datum, inferredType.Ft, v.maskFunc)
+			maskedDatum, maskedType, masked, err = v.mask(originExpr.Datum, inferredType)
 		}
-
 		if err != nil {
 			v.appendError(err)
 			return originExpr, false
 		}
+		if inferredType.IsAmbiguous() {
+			v.appendError(&AmbiguousTypeError{constantPosition(v.originExprs, m), inferredType})
+		}
+		if !masked {
+			// use original datum if int pk is ignored
+			maskedDatum, maskedType = originExpr.Datum, &originExpr.Type
+		}
 
 		restoredExpr := ast.NewValueExpr(maskedDatum.GetValue(), "", "")
 		restoredExpr.SetType(maskedType)
@@ -0,0 +1,80 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipRules(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+	w.ShowValues = true
+
+	cases := []struct {
+		sql  string
+		kept string
+	}{
+		{"SELECT * FROM customer WHERE c_id = 1 LIMIT 10, 20", "LIMIT 10,20"},
+		{"SELECT SUM(c_id) OVER (ORDER BY c_id ROWS 2 PRECEDING) FROM customer WHERE c_id = 1", "ROWS BETWEEN 2 PRECEDING"},
+		{"SELECT COUNT(*) FROM customer WHERE c_id = 1", "COUNT(1)"},
+		{"SELECT c_last FROM customer WHERE c_id = 1 ORDER BY 1", "ORDER BY 1"},
+		{"SELECT c_last, COUNT(c_id) FROM customer WHERE c_id = 1 GROUP BY 1", "GROUP BY `c_last`"}, // resolved by the planner
+		{"SELECT GROUP_CONCAT(c_last SEPARATOR ';') FROM customer WHERE c_id = 1", "SEPARATOR ';'"},
+		{"SELECT CONVERT(c_last USING utf8) FROM customer WHERE c_id = 1", "USING 'utf8'"},
+		{"SELECT JSON_EXTRACT(c_data, '$.x') FROM customer WHERE c_id = 1", "'$.x'"},
+		{"SELECT DATE_FORMAT(c_since, '%Y-%m') FROM customer WHERE c_id = 1", "'%Y-%m'"},
+		{"SELECT DATE_ADD(c_since, INTERVAL 3 DAY) FROM customer WHERE c_id = 1", "INTERVAL 3 DAY"},
+		{"SELECT SUBSTRING(c_last, 2, 3) FROM customer WHERE c_id = 1", "2, 3"},
+	}
+	for _, c := range cases {
+		result := w.MaskOneResult(c.sql)
+		require.Equal(t, StatusSuccess, result.Status, c.sql)
+		require.Contains(t, result.Masked, c.kept, c.sql)
+		// only `c_id = 1` is masked
+		require.Equal(t, []ConstantResult{{Value: "1", Type: "int(11)", Columns: []string{"test.customer.c_id"}}}, result.Constants, c.sql)
+	}
+}
+
+func TestNewSkipList(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	sql := "SELECT JSON_SET(c_data, '$.a', 'x', '$.b', 'y') FROM customer LIMIT 1"
+	replacedValues := func(l *SkipList) []string {
+		node, err := db.ParseOne(sql)
+		require.Nil(t, err)
+		v := NewReplaceVisitor(ReplaceModeValue)
+		v.structural.list = l
+		node.Accept(v)
+
+		values := []string{}
+		for _, c := range sortedInferredConstants(v.OriginExprs, nil) {
+			value, err := c.Value.ToString()
+			require.Nil(t, err)
+			values = append(values, value)
+		}
+		return values
+	}
+
+	l, err := NewSkipList(nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"$.a", "x", "$.b", "y"}, replacedValues(l))
+
+	l, err = NewSkipList(nil, []string{"JSON_SET:2,4"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"x", "y"}, replacedValues(l))
+
+	l, err = NewSkipList([]string{"func-args"}, []string{"json_set:2", "json_set:4"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"x", "y", "1"}, replacedValues(l))
+
+	_, err = NewSkipList([]string{"no-such-rule"}, nil)
+	require.NotNil(t, err)
+	for _, arg := range []string{"json_set", ":2", "json_set:0", "json_set:a"} {
+		_, err = NewSkipList(nil, []string{arg})
+		require.NotNil(t, err, arg)
+	}
+}
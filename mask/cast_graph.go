@@ -2,12 +2,18 @@ package mask
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
 	"github.com/pingcap/tidb/expression"
 	"github.com/pingcap/tidb/kv"
 	plannercore "github.com/pingcap/tidb/planner/core"
+	"github.com/pingcap/tidb/planner/property"
+	"github.com/pingcap/tidb/planner/util"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/ranger"
 )
 
 type Expr = expression.Expression
@@ -22,10 +28,19 @@ type Node interface {
 var _ Node = CastNode{}
 var _ Node = NormalNode{}
 
+// A node for `CAST` function, identified by the function itself so that it's shared by its
+// argument and its parent expression
 type CastNode struct {
 	Node
-	left  *InferredType
-	right *InferredType
+	fn *expression.ScalarFunction
+}
+
+func (n CastNode) left() *InferredType {
+	return NewInferredType(n.fn.GetArgs()[0].GetType())
+}
+
+func (n CastNode) right() *InferredType {
+	return NewInferredType(n.fn.GetType())
 }
 
 type NormalNode struct {
@@ -44,12 +59,8 @@ func (g *CastGraph) Add(a Expr, b Expr) bool {
 	asNode := func(e Expr) Node {
 		switch e := e.(type) {
 		case *expression.ScalarFunction:
-			if e.FuncName.L == "cast" {
-				t1 := e.GetArgs()[0].GetType()
-				t2 := e.GetType()
-				return CastNode{
-					left: NewInferredType(t1), right: NewInferredType(t2),
-				}
+			if e.FuncName.L == ast.Cast {
+				return CastNode{fn: e}
 			}
 		}
 		return NormalNode{expr: e}
@@ -76,16 +87,19 @@ func (g *CastGraph) doInfer(u Node, currType *InferredType, visited map[Node]boo
 		}
 		switch v := v.(type) {
 		case CastNode:
-			if currType.Ft.EvalType() == v.left.Ft.EvalType() {
-				possibleTypes = append(possibleTypes, g.doInfer(v, v.right, visited)...)
-			} else if currType.Ft.EvalType() == v.right.Ft.EvalType() {
-				possibleTypes = append(possibleTypes, g.doInfer(v, v.left, visited)...)
+			left, right := v.left(), v.right()
+			if currType.Ft.EvalType() == left.Ft.EvalType() {
+				possibleTypes = append(possibleTypes, g.doInfer(v, right, visited)...)
+			} else if currType.Ft.EvalType() == right.Ft.EvalType() {
+				possibleTypes = append(possibleTypes, g.doInfer(v, left, visited)...)
 			}
 		case NormalNode:
-			if currType.Ft.EvalType() == v.expr.GetType().EvalType() {
+			if column, ok := v.expr.(*expression.Column); ok {
+				possibleTypes = append(possibleTypes, NewColumnInferredType(column))
+			} else if column, ok := v.expr.(*expression.CorrelatedColumn); ok {
+				possibleTypes = append(possibleTypes, NewColumnInferredType(&column.Column))
+			} else if currType.Ft.EvalType() == v.expr.GetType().EvalType() {
 				possibleTypes = append(possibleTypes, NewInferredType(v.expr.GetType()))
-			} else if column, ok := v.expr.(*expression.Column); ok {
-				possibleTypes = append(possibleTypes, NewInferredType(column.GetType()))
 			}
 		default:
 		}
@@ -97,13 +111,91 @@ func (g *CastGraph) doInfer(u Node, currType *InferredType, visited map[Node]boo
 	return possibleTypes
 }
 
+// Infer the type of constant `c`, with all columns that it's inferred against in `SourceColumns`
 func (g *CastGraph) InferType(c *expression.Constant) *InferredType {
 	u := NormalNode{expr: c}
 	t := c.GetType()
 	visited := make(map[Node]bool)
 
 	possibleTypes := g.doInfer(u, NewInferredType(t), visited)
-	return possibleTypes[0]
+
+	columns := []*expression.Column{}
+	seen := make(map[*expression.Column]bool)
+	for _, tp := range possibleTypes {
+		if tp.Column != nil && !seen[tp.Column] {
+			seen[tp.Column] = true
+			columns = append(columns, tp.Column)
+		}
+	}
+
+	return chooseType(possibleTypes, columns)
+}
+
+// Choose a type from `possibleTypes` deterministically: types of columns are preferred, then
+// the widest one. Columns of different eval types are kept in `Candidates` of the result, so
+// that the constant can be reported as ambiguous.
+func chooseType(possibleTypes []*InferredType, sourceColumns []*expression.Column) *InferredType {
+	candidates := []*InferredType{}
+	seen := make(map[string]bool)
+	for _, tp := range possibleTypes {
+		if key := tp.key(); !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, tp)
+		}
+	}
+
+	columnTypes := []*InferredType{}
+	for _, tp := range candidates {
+		if tp.Column != nil {
+			columnTypes = append(columnTypes, tp)
+		}
+	}
+	if len(columnTypes) > 0 {
+		candidates = columnTypes
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return wider(candidates[i], candidates[j]) })
+
+	tp := *candidates[0]
+	tp.SourceColumns = sourceColumns
+	tp.Candidates = nil
+	for _, c := range columnTypes {
+		if c.Ft.EvalType() != tp.Ft.EvalType() {
+			tp.Candidates = candidates
+			break
+		}
+	}
+	return &tp
+}
+
+// Merge two types inferred for the same constant, like those of pushed-down conditions
+func mergeTypes(a *InferredType, b *InferredType) *InferredType {
+	possibleTypes := append(a.candidates(), b.candidates()...)
+	return chooseType(possibleTypes, mergeColumns(a.SourceColumns, b.SourceColumns))
+}
+
+var evalTypeWidths = map[types.EvalType]int{
+	types.ETInt:       0,
+	types.ETDecimal:   1,
+	types.ETReal:      2,
+	types.ETDuration:  3,
+	types.ETTimestamp: 4,
+	types.ETDatetime:  4,
+	types.ETJson:      5,
+	types.ETString:    6,
+}
+
+// Whether `a` is wider than `b`, ties are broken by names for a stable order
+func wider(a *InferredType, b *InferredType) bool {
+	if wa, wb := evalTypeWidths[a.Ft.EvalType()], evalTypeWidths[b.Ft.EvalType()]; wa != wb {
+		return wa > wb
+	}
+	if a.Ft.Flen != b.Ft.Flen {
+		return a.Ft.Flen > b.Ft.Flen
+	}
+	if a.Ft.Decimal != b.Ft.Decimal {
+		return a.Ft.Decimal > b.Ft.Decimal
+	}
+	return a.key() < b.key()
 }
 
 // A visitor for physical plans, which extract sconstants for masking,
@@ -121,42 +213,101 @@ func NewCastGraphBuilder() *CastGraphBuilder {
 	}
 }
 
-func (v *CastGraphBuilder) visitUpdate(update plannercore.Update) {
-	v.visitPhysicalPlan(update.SelectPlan)
-	for _, assignment := range update.OrderedList {
+func (v *CastGraphBuilder) visitAssignments(assignments []*expression.Assignment) {
+	for _, assignment := range assignments {
 		v.Graph.Add(assignment.Col, assignment.Expr)
 		v.visitExpr(assignment.Col)
 		v.visitExpr(assignment.Expr)
 	}
 }
 
+func (v *CastGraphBuilder) visitUpdate(update plannercore.Update) {
+	v.visitPhysicalPlan(update.SelectPlan)
+	v.visitAssignments(update.OrderedList)
+}
+
 func (v *CastGraphBuilder) visitDelete(delete plannercore.Delete) {
 	v.visitPhysicalPlan(delete.SelectPlan)
 }
 
-func (v *CastGraphBuilder) visitInsert(insert plannercore.Insert) {
-	v.visitPhysicalPlan(insert.SelectPlan)
-
+// Columns that values in `insert.Lists` are for. All visible columns are used if the column
+// list is not given, and generated columns are skipped since their only permitted value
+// `DEFAULT` is not kept in the lists.
+func insertValueColumns(insert plannercore.Insert) ([]*expression.Column, error) {
 	columnMap := make(map[string]*expression.Column)
 	for _, col := range insert.Schema4OnDuplicate.Columns {
 		tokens := strings.Split(strings.ToLower(col.OrigName), ".")
 		name := tokens[len(tokens)-1]
-		columnMap[name] = col
+		// columns of the table come first, before those of the select plan
+		if _, ok := columnMap[name]; !ok {
+			columnMap[name] = col
+		}
 	}
 
-	for _, list := range insert.Lists {
-		if len(list) != len(insert.Columns) {
-			panic("bad insert columns number")
+	names := make([]string, 0, len(insert.Columns))
+	for _, col := range insert.Columns {
+		names = append(names, col.Name.L)
+	}
+	generated := make(map[string]bool)
+	for _, col := range insert.Table.VisibleCols() {
+		if len(insert.Columns) == 0 {
+			names = append(names, col.Name.L)
+		}
+		generated[col.Name.L] = col.IsGenerated()
+	}
+
+	columns := make([]*expression.Column, 0, len(names))
+	for _, name := range names {
+		if generated[name] {
+			continue
 		}
-		for i, expr := range list {
-			colName := insert.Columns[i].Name.L
-			if col, ok := columnMap[colName]; ok {
-				v.Graph.Add(col, expr)
+		col, ok := columnMap[name]
+		if !ok {
+			return nil, fmt.Errorf("column `%s` not found in insert table", name)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+func (v *CastGraphBuilder) visitInsert(insert plannercore.Insert) error {
+	v.visitPhysicalPlan(insert.SelectPlan)
+
+	if len(insert.Lists) > 0 || insert.SelectPlan != nil {
+		columns, err := insertValueColumns(insert)
+		if err != nil {
+			return err
+		}
+		if insert.SelectPlan != nil {
+			// `INSERT ... SELECT`, where outputs of the select plan are for columns in order
+			exprs := outputExprs(insert.SelectPlan)
+			if len(exprs) < len(columns) {
+				return fmt.Errorf("bad number of selected columns: %d for %d columns", len(exprs), len(columns))
+			}
+			for i, col := range columns {
+				v.Graph.Add(col, exprs[i])
 				v.visitExpr(col)
+			}
+		}
+		for i, list := range insert.Lists {
+			if len(list) == 0 { // `VALUES ()` for default values
+				continue
+			}
+			if len(list) != len(columns) {
+				return fmt.Errorf("bad number of values in row %d: %d values for %d columns", i+1, len(list), len(columns))
+			}
+			for j, expr := range list {
+				v.Graph.Add(columns[j], expr)
+				v.visitExpr(columns[j])
 				v.visitExpr(expr)
 			}
 		}
 	}
+
+	// `INSERT ... SET` and `ON DUPLICATE KEY UPDATE`
+	v.visitAssignments(insert.SetList)
+	v.visitAssignments(insert.OnDuplicate)
+	return nil
 }
 
 func (b *CastGraphBuilder) Build(plan plannercore.Plan) error {
@@ -168,7 +319,7 @@ func (b *CastGraphBuilder) Build(plan plannercore.Plan) error {
 	case *plannercore.Delete:
 		b.visitDelete(*plan)
 	case *plannercore.Insert:
-		b.visitInsert(*plan)
+		return b.visitInsert(*plan)
 	case *plannercore.Execute:
 		_ = b.Build(plan.Plan)
 	case *plannercore.Simple:
@@ -192,17 +343,40 @@ func (v *CastGraphBuilder) visitPhysicalPlan(plans ...plannercore.PhysicalPlan)
 		switch p := plan.(type) {
 		case *plannercore.PhysicalTableReader:
 			v.visitPhysicalPlan(p.TablePlans...)
+		case *plannercore.PhysicalIndexReader:
+			v.visitPhysicalPlan(p.IndexPlans...)
+		case *plannercore.PhysicalIndexLookUpReader:
+			v.visitPhysicalPlan(p.IndexPlans...)
+			v.visitPhysicalPlan(p.TablePlans...)
+		case *plannercore.PhysicalIndexMergeReader:
+			for _, partialPlans := range p.PartialPlans {
+				v.visitPhysicalPlan(partialPlans...)
+			}
+			v.visitPhysicalPlan(p.TablePlans...)
 		case *plannercore.PhysicalSelection:
 			v.visitExpr(p.Conditions...)
+		case *plannercore.PhysicalUnionScan:
+			v.visitExpr(p.Conditions...)
 		case *plannercore.PhysicalTableScan:
 			v.visitExpr(p.AccessCondition...)
+			if p.HandleCols != nil && p.HandleCols.IsInt() {
+				v.visitRanges(p.Ranges, []*expression.Column{p.HandleCols.GetCol(0)})
+			}
+		case *plannercore.PhysicalIndexScan:
+			v.visitExpr(p.AccessCondition...)
+			v.visitRanges(p.Ranges, p.IdxCols)
 		case *plannercore.PhysicalProjection:
 			v.visitExpr(p.Exprs...)
 		case *plannercore.PointGetPlan:
 			v.visitExpr(p.AccessConditions...)
+			v.visitIndexValues(p.IndexValues, pointGetIndexColumns(p, p.IdxCols, p.IndexInfo, p.TblInfo))
 			v.Handles = append(v.Handles, p.Handle)
 		case *plannercore.BatchPointGetPlan:
 			v.visitExpr(p.AccessConditions...)
+			cols := pointGetIndexColumns(p, p.IdxCols, p.IndexInfo, p.TblInfo)
+			for _, values := range p.IndexValues {
+				v.visitIndexValues(values, cols)
+			}
 			v.Handles = append(v.Handles, p.Handles...)
 		case *plannercore.PhysicalStreamAgg:
 			for _, fn := range p.AggFuncs {
@@ -215,32 +389,226 @@ func (v *CastGraphBuilder) visitPhysicalPlan(plans ...plannercore.PhysicalPlan)
 			}
 			v.visitExpr(p.GroupByItems...)
 		case *plannercore.PhysicalHashJoin:
-			for _, fn := range p.EqualConditions {
-				v.visitExpr(fn)
+			v.visitHashJoin(p)
+		case *plannercore.PhysicalApply:
+			// the inner plan is visited as a child, where correlated columns of the outer plan
+			// are linked with constants like `o_c_id = c.c_id + 1`
+			v.visitHashJoin(&p.PhysicalHashJoin)
+			for _, col := range p.OuterSchema {
+				v.visitExpr(col)
 			}
+		case *plannercore.PhysicalMergeJoin:
+			v.visitJoin(p.LeftJoinKeys, p.RightJoinKeys, p.LeftConditions, p.RightConditions, p.OtherConditions)
+		case *plannercore.PhysicalIndexJoin:
+			v.visitIndexJoin(p)
+		case *plannercore.PhysicalIndexHashJoin:
+			v.visitIndexJoin(&p.PhysicalIndexJoin)
+		case *plannercore.PhysicalIndexMergeJoin:
+			v.visitIndexJoin(&p.PhysicalIndexJoin)
 		case *plannercore.PhysicalSort:
-			exprs := []Expr{}
-			for _, by := range p.ByItems {
-				exprs = append(exprs, by.Expr)
-			}
-			v.visitExpr(exprs...)
+			v.visitByItems(p.ByItems)
+		case *plannercore.PhysicalTopN:
+			v.visitByItems(p.ByItems)
+		case *plannercore.PhysicalLimit:
+			// constants in `LIMIT` are not replaced, see `SkipRules`
+		case *plannercore.PhysicalWindow:
+			v.visitWindow(p)
+		case *plannercore.PhysicalUnionAll:
+			v.visitUnionAll(p)
+		case *plannercore.PhysicalCTE:
+			v.visitCTE(p)
 		default:
 		}
 	}
 }
 
+func (v *CastGraphBuilder) visitByItems(byItems []*util.ByItems) {
+	exprs := []Expr{}
+	for _, by := range byItems {
+		exprs = append(exprs, by.Expr)
+	}
+	v.visitExpr(exprs...)
+}
+
+func (v *CastGraphBuilder) visitHashJoin(p *plannercore.PhysicalHashJoin) {
+	for _, fn := range p.EqualConditions {
+		v.visitExpr(fn)
+	}
+	v.visitJoin(nil, nil, p.LeftConditions, p.RightConditions, p.OtherConditions)
+}
+
+// Visit conditions of joins, keys at the same offsets are compared with each other
+func (v *CastGraphBuilder) visitJoin(leftKeys, rightKeys []*expression.Column, conditions ...expression.CNFExprs) {
+	for i := range leftKeys {
+		if i < len(rightKeys) {
+			v.Graph.Add(leftKeys[i], rightKeys[i])
+		}
+	}
+	for _, exprs := range conditions {
+		v.visitExpr(exprs...)
+	}
+}
+
+func (v *CastGraphBuilder) visitIndexJoin(p *plannercore.PhysicalIndexJoin) {
+	v.visitJoin(p.OuterJoinKeys, p.InnerJoinKeys, p.LeftConditions, p.RightConditions, p.OtherConditions)
+	// ranges of the inner index are built from join keys and constants, where positions of
+	// join keys are left empty, like `s_i_id = 10` in `ON s_w_id = o_c_id AND s_i_id = 10`
+	if children := p.Children(); p.InnerChildIdx < len(children) {
+		v.visitRanges(p.Ranges, innerIndexColumns(children[p.InnerChildIdx]))
+	}
+}
+
+// Columns of the index scanned by the inner child of an index join, nil if not found
+func innerIndexColumns(plan plannercore.PhysicalPlan) []*expression.Column {
+	switch p := plan.(type) {
+	case *plannercore.PhysicalIndexScan:
+		return p.IdxCols
+	case *plannercore.PhysicalIndexReader:
+		if len(p.IndexPlans) > 0 {
+			return innerIndexColumns(p.IndexPlans[0])
+		}
+	case *plannercore.PhysicalIndexLookUpReader:
+		if len(p.IndexPlans) > 0 {
+			return innerIndexColumns(p.IndexPlans[0])
+		}
+	}
+	for _, child := range plan.Children() {
+		if cols := innerIndexColumns(child); cols != nil {
+			return cols
+		}
+	}
+	return nil
+}
+
+// Columns of the index accessed by a point get plan, `idxCols` is not set for plans built
+// in the fast path, so build them from the table info with full original names
+func pointGetIndexColumns(plan plannercore.PhysicalPlan, idxCols []*expression.Column, idx *model.IndexInfo, tbl *model.TableInfo) []*expression.Column {
+	if len(idxCols) > 0 || idx == nil || tbl == nil {
+		return idxCols
+	}
+
+	db := ""
+	if names := plan.OutputNames(); len(names) > 0 {
+		db = names[0].DBName.L
+	}
+	cols := make([]*expression.Column, 0, len(idx.Columns))
+	for _, idxCol := range idx.Columns {
+		info := tbl.Columns[idxCol.Offset]
+		cols = append(cols, &expression.Column{
+			ID:       info.ID,
+			RetType:  &info.FieldType,
+			OrigName: fmt.Sprintf("%s.%s.%s", db, tbl.Name.L, info.Name.L),
+		})
+	}
+	return cols
+}
+
+// Constants folded into ranges or index values by the planner do not appear in expressions
+// anymore, so treat each value as a constant compared with the column at its position
+func (v *CastGraphBuilder) visitIndexValues(values []types.Datum, cols []*expression.Column) {
+	for i, value := range values {
+		if i >= len(cols) {
+			break
+		}
+		switch value.Kind() {
+		case types.KindNull, types.KindMinNotNull, types.KindMaxValue:
+			continue
+		}
+		c := &expression.Constant{Value: value, RetType: cols[i].GetType()}
+		v.Graph.Add(cols[i], c)
+		v.visitExpr(cols[i], c)
+	}
+}
+
+func (v *CastGraphBuilder) visitRanges(ranges []*ranger.Range, cols []*expression.Column) {
+	for _, r := range ranges {
+		v.visitIndexValues(r.LowVal, cols)
+		v.visitIndexValues(r.HighVal, cols)
+	}
+}
+
+func (v *CastGraphBuilder) visitWindow(p *plannercore.PhysicalWindow) {
+	for _, desc := range p.WindowFuncDescs {
+		// the default value of `LEAD` and `LAG` is of the same type as the first argument
+		if (desc.Name == ast.WindowFuncLead || desc.Name == ast.WindowFuncLag) && len(desc.Args) == 3 {
+			v.Graph.Add(desc.Args[0], desc.Args[2])
+		}
+		v.visitExpr(desc.Args...)
+	}
+	for _, item := range append(append([]property.SortItem{}, p.PartitionBy...), p.OrderBy...) {
+		v.visitExpr(item.Col)
+	}
+	if p.Frame != nil {
+		for _, bound := range []*plannercore.FrameBound{p.Frame.Start, p.Frame.End} {
+			if bound != nil {
+				v.visitExpr(bound.CalcFuncs...)
+			}
+		}
+	}
+}
+
+// Output expressions of a plan, like children of `UnionAll` or the select plan of `INSERT`,
+// which are usually projections
+func outputExprs(plan plannercore.PhysicalPlan) []Expr {
+	if p, ok := plan.(*plannercore.PhysicalProjection); ok {
+		return p.Exprs
+	}
+	exprs := []Expr{}
+	for _, col := range plan.Schema().Columns {
+		exprs = append(exprs, col)
+	}
+	return exprs
+}
+
+// Outputs of all children at the same offset are unioned into one column, so they're considered
+// to be compared with each other, e.g., `SELECT c_id FROM t UNION ALL SELECT 42`
+func (v *CastGraphBuilder) visitUnionAll(p *plannercore.PhysicalUnionAll) {
+	children := p.Children()
+	if len(children) < 2 {
+		return
+	}
+	first := outputExprs(children[0])
+	for _, child := range children[1:] {
+		exprs := outputExprs(child)
+		for i := range exprs {
+			if i < len(first) {
+				v.Graph.Add(first[i], exprs[i])
+			}
+		}
+	}
+}
+
+// Producer plans of a CTE are not children of it, and their outputs are unioned into columns
+// of the CTE, e.g., `WITH RECURSIVE cte (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM cte)`
+func (v *CastGraphBuilder) visitCTE(p *plannercore.PhysicalCTE) {
+	for _, plan := range []plannercore.PhysicalPlan{p.SeedPlan, p.RecurPlan} {
+		if plan == nil {
+			continue
+		}
+		v.visitPhysicalPlan(plan)
+		exprs := outputExprs(plan)
+		for i, col := range p.Schema().Columns {
+			if i < len(exprs) {
+				v.Graph.Add(col, exprs[i])
+			}
+		}
+	}
+}
+
 func (v *CastGraphBuilder) visitExpr(exprs ...Expr) {
 	for _, expr := range exprs {
 		switch e := expr.(type) {
 		case *expression.ScalarFunction:
 			args := e.GetArgs()
 			if e.FuncName.L == ast.Cast {
-				v.Graph.Add(args[0], expr)
-			} else if len(args) == 2 {
-				left, right := args[0], args[1]
-				if left.GetType().EvalType() == right.GetType().EvalType() {
-					v.Graph.Add(left, right)
+				for _, arg := range passThroughArgs(args[0]) {
+					v.Graph.Add(arg, expr)
 				}
+			} else if sig, ok := funcSignatures[e.FuncName.L]; ok {
+				v.visitFunc(sig, args)
+				continue
+			} else if len(args) == 2 {
+				v.link(args[0], args[1])
 			}
 			for _, expr := range args {
 				v.visitExpr(expr)
@@ -249,6 +617,72 @@ func (v *CastGraphBuilder) visitExpr(exprs ...Expr) {
 			v.Constants = append(v.Constants, e)
 		case *expression.Column:
 			v.Columns = append(v.Columns, e)
+		case *expression.CorrelatedColumn:
+			v.Columns = append(v.Columns, &e.Column)
+		}
+	}
+}
+
+// Visit arguments of a function by their roles in `sig`, see `funcSignatures`
+func (v *CastGraphBuilder) visitFunc(sig funcSignature, args []Expr) {
+	data := []Expr{}
+	for i, arg := range args {
+		switch sig.role(i, len(args)) {
+		case argData:
+			data = append(data, arg)
+			v.visitExpr(arg)
+		case argOther:
+			v.visitExpr(arg)
+		case argStructural:
+			v.visitColumns(arg)
+		}
+	}
+	v.link(data...)
+}
+
+// Visit columns only, constants in `expr` are never masked
+func (v *CastGraphBuilder) visitColumns(expr Expr) {
+	for _, col := range expression.ExtractColumns(expr) {
+		v.Columns = append(v.Columns, col)
+	}
+}
+
+// Link each pair of `exprs` of the same eval type. Pass-through functions like `COALESCE` are
+// expanded into their data arguments, so that constants in them are linked with the other side.
+func (v *CastGraphBuilder) link(exprs ...Expr) {
+	expanded := make([][]Expr, 0, len(exprs))
+	for _, expr := range exprs {
+		expanded = append(expanded, passThroughArgs(expr))
+	}
+	for i := range expanded {
+		for j := i + 1; j < len(expanded); j++ {
+			for _, a := range expanded[i] {
+				for _, b := range expanded[j] {
+					if a.GetType().EvalType() == b.GetType().EvalType() {
+						v.Graph.Add(a, b)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Data arguments that `expr` may evaluate to, or `expr` itself if it's not pass-through
+func passThroughArgs(expr Expr) []Expr {
+	fn, ok := expr.(*expression.ScalarFunction)
+	if !ok {
+		return []Expr{expr}
+	}
+	sig, ok := funcSignatures[fn.FuncName.L]
+	if !ok || !sig.passThrough {
+		return []Expr{expr}
+	}
+	args := fn.GetArgs()
+	expanded := []Expr{}
+	for i, arg := range args {
+		if sig.role(i, len(args)) == argData {
+			expanded = append(expanded, passThroughArgs(arg)...)
 		}
 	}
+	return expanded
 }
@@ -0,0 +1,314 @@
+package mask
+
+import (
+	"testing"
+
+	plannercore "github.com/pingcap/tidb/planner/core"
+	"github.com/stretchr/testify/require"
+)
+
+// Check that the plan of `sql` contains `operator`, and all constants in it are inferred against
+// `columns` in order, where an empty column means the constant is inferred without a column
+func requireInferredWithPlan(t *testing.T, sql string, operator string, columns ...string) {
+	db := newTestDB(t)
+	stmt, err := db.Compile(sql)
+	require.Nil(t, err)
+	require.Contains(t, plannercore.ToString(stmt.Plan), operator)
+
+	w := NewSQLWorker(db, MaskFuncMap["identical"], nil, false, nil)
+	constants, err := w.InferConstants(sql)
+	require.Nil(t, err)
+	require.Len(t, constants, len(columns))
+	for i, c := range constants {
+		require.NotNil(t, c.Type, "constant `%v` not inferred", c.Value)
+		require.Equal(t, columns[i], c.Type.ColumnName(), "constant `%v`", c.Value)
+	}
+}
+
+func TestInferIndexReader(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT o_c_id FROM orders WHERE o_c_id = 5",
+		"IndexReader(Index(orders.idx_c_id)",
+		"test.orders.o_c_id",
+	)
+}
+
+func TestInferIndexLookUpReader(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT * FROM orders USE INDEX (idx_c_id) WHERE o_c_id = 5 AND o_carrier_id = 3",
+		"IndexLookUp(",
+		"test.orders.o_c_id", "test.orders.o_carrier_id",
+	)
+}
+
+func TestInferIndexMergeReader(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT /*+ USE_INDEX_MERGE(orders) */ * FROM orders WHERE o_id = 1 OR o_c_id = 2",
+		"IndexMergeReader(",
+		"test.orders.o_id", "test.orders.o_c_id",
+	)
+}
+
+func TestInferIndexJoin(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT /*+ INL_JOIN(orders) */ * FROM customer JOIN orders ON o_c_id = c_id WHERE c_last = 'x' AND o_carrier_id = 3",
+		"IndexJoin{",
+		"test.customer.c_last", "test.orders.o_carrier_id",
+	)
+}
+
+func TestInferIndexHashJoin(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT /*+ INL_HASH_JOIN(orders) */ * FROM customer JOIN orders ON o_c_id = c_id WHERE c_last = 'x' AND o_carrier_id = 3",
+		"IndexHashJoin{",
+		"test.customer.c_last", "test.orders.o_carrier_id",
+	)
+}
+
+func TestInferMergeJoin(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT /*+ MERGE_JOIN(customer, orders) */ * FROM customer JOIN orders ON o_c_id = c_id AND o_carrier_id > c_d_id + 3",
+		"MergeInnerJoin{",
+		"test.customer.c_d_id",
+	)
+}
+
+func TestInferHashJoinConditions(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT /*+ HASH_JOIN(customer, orders) */ * FROM customer LEFT JOIN orders ON o_c_id = c_id AND o_carrier_id > c_d_id + 7",
+		"LeftHashJoin{",
+		"test.customer.c_d_id",
+	)
+}
+
+func TestInferApply(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT (SELECT o_entry_d FROM orders WHERE o_c_id = customer.c_id AND o_carrier_id = 5 ORDER BY o_id LIMIT 1) FROM customer WHERE c_d_id = 2",
+		"Apply{",
+		"test.orders.o_carrier_id", "test.customer.c_d_id",
+	)
+
+	// constants compared with correlated columns in the inner plan
+	requireInferredWithPlan(t,
+		"SELECT * FROM customer WHERE c_balance > (SELECT o_carrier_id FROM orders WHERE o_c_id = customer.c_id + 1 AND o_entry_d > '2021-01-01' ORDER BY o_id LIMIT 1)",
+		"Apply{",
+		"test.customer.c_id", "test.orders.o_entry_d",
+	)
+	requireInferredWithPlan(t,
+		"SELECT * FROM customer WHERE c_last IN (SELECT s_data FROM stock WHERE s_quantity = customer.c_d_id - 4 ORDER BY s_i_id LIMIT 1)",
+		"Apply{",
+		"test.customer.c_d_id",
+	)
+}
+
+func TestInferWindow(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT SUM(c_balance + 1.5) OVER (PARTITION BY c_d_id ORDER BY c_id), LAG(c_last, 1, 'none') OVER (ORDER BY c_id) FROM customer",
+		"Window(",
+		// the offset of `LAG` is inferred without a column
+		"test.customer.c_balance", "", "test.customer.c_last",
+	)
+
+	// offsets of `ROWS` frames are like `LIMIT`, which are not masked
+	requireInferredWithPlan(t,
+		"SELECT SUM(c_balance) OVER (ORDER BY c_balance RANGE BETWEEN 2.5 PRECEDING AND CURRENT ROW), SUM(c_balance) OVER (ROWS 2 PRECEDING) FROM customer",
+		"Window(",
+		"test.customer.c_balance",
+	)
+}
+
+func TestInferTopN(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT * FROM customer ORDER BY c_balance + 2.5 LIMIT 10",
+		"TopN(",
+		"test.customer.c_balance",
+	)
+}
+
+func TestInferLimit(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT * FROM customer WHERE c_id = 3 LIMIT 5, 10",
+		"Limit",
+		"test.customer.c_id",
+	)
+}
+
+func TestInferUnionAll(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT c_id, c_last FROM customer WHERE c_d_id = 1 UNION ALL SELECT 42, 'Alice'",
+		"UnionAll{",
+		"test.customer.c_d_id", "test.customer.c_id", "test.customer.c_last",
+	)
+}
+
+func TestInferIndexRange(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT * FROM stock WHERE s_w_id = 7 AND s_i_id BETWEEN 8 AND 9",
+		"Index(stock.primary)[[7 8,7 9]]",
+		"test.stock.s_w_id", "test.stock.s_i_id", "test.stock.s_i_id",
+	)
+}
+
+func TestInferPointGet(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT * FROM stock WHERE s_w_id = 1 AND s_i_id = 2",
+		"PointGet(Index(stock.primary)",
+		"test.stock.s_w_id", "test.stock.s_i_id",
+	)
+	requireInferredWithPlan(t,
+		"SELECT * FROM stock WHERE s_data = 'abc'",
+		"PointGet(Index(stock.uk_data)",
+		"test.stock.s_data",
+	)
+}
+
+func TestInferBatchPointGet(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT * FROM stock WHERE (s_w_id, s_i_id) IN ((3, 4), (5, 6))",
+		"BatchPointGet(Index(stock.primary)",
+		"test.stock.s_w_id", "test.stock.s_i_id", "test.stock.s_w_id", "test.stock.s_i_id",
+	)
+	requireInferredWithPlan(t,
+		"SELECT * FROM stock WHERE s_w_id = 12 AND s_i_id IN (13, 14)",
+		"BatchPointGet(Index(stock.primary)",
+		"test.stock.s_w_id", "test.stock.s_i_id", "test.stock.s_i_id",
+	)
+}
+
+func TestInferIndexJoinRange(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"SELECT /*+ INL_JOIN(stock) */ * FROM orders JOIN stock ON s_w_id = o_c_id AND s_i_id = 10",
+		"IndexJoin{",
+		"test.stock.s_i_id",
+	)
+}
+
+func TestInferInsert(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		sql     string
+		columns []string
+	}{
+		{
+			"INSERT INTO item VALUES (1, 'a', 2.5, DEFAULT, 'd')",
+			[]string{"test.item.i_id", "test.item.i_name", "test.item.i_price", "test.item.i_data"},
+		},
+		{
+			"INSERT INTO item (i_id, i_price, i_tax) VALUES (1, 2.5, DEFAULT), (2, 3.5, DEFAULT)",
+			[]string{"test.item.i_id", "test.item.i_price", "test.item.i_id", "test.item.i_price"},
+		},
+		{
+			"INSERT INTO item SET i_id = 1, i_name = 'a', i_tax = DEFAULT",
+			[]string{"test.item.i_id", "test.item.i_name"},
+		},
+		{
+			"REPLACE INTO item VALUES (1, 'a', 2.5, DEFAULT, 'd')",
+			[]string{"test.item.i_id", "test.item.i_name", "test.item.i_price", "test.item.i_data"},
+		},
+		{
+			"REPLACE INTO item SET i_id = 1, i_data = 'x'",
+			[]string{"test.item.i_id", "test.item.i_data"},
+		},
+		{
+			"INSERT INTO item (i_id, i_price) VALUES (1, 2.5) ON DUPLICATE KEY UPDATE i_price = VALUES(i_price) + 1, i_name = 'b'",
+			// the type of `VALUES(i_price)` is inferred without a column
+			[]string{"test.item.i_id", "test.item.i_price", "", "test.item.i_name"},
+		},
+		{
+			"INSERT INTO item (i_id, i_name) SELECT o_id, 'x' FROM orders WHERE o_c_id = 3 ON DUPLICATE KEY UPDATE i_data = 'y'",
+			[]string{"test.item.i_name", "test.orders.o_c_id", "test.item.i_data"},
+		},
+		{
+			"INSERT INTO item VALUES ()",
+			nil,
+		},
+	}
+	for _, c := range cases {
+		requireInferredWithPlan(t, c.sql, "", c.columns...)
+	}
+}
+
+func TestInferCTE(t *testing.T) {
+	t.Parallel()
+
+	requireInferredWithPlan(t,
+		"WITH cte AS (SELECT c_id, c_last FROM customer WHERE c_d_id = 1) SELECT * FROM cte WHERE c_last = 'a'",
+		"PhysicalCTE",
+		"test.customer.c_d_id", "test.customer.c_last",
+	)
+	requireInferredWithPlan(t,
+		"WITH RECURSIVE cte (id, d) AS (SELECT c_id, c_d_id FROM customer WHERE c_id = 1 UNION ALL SELECT c_id, d + 1 FROM cte JOIN customer ON c_id = id WHERE d < 5) SELECT * FROM cte",
+		"PhysicalCTE",
+		"test.customer.c_id", "test.customer.c_d_id", "test.customer.c_d_id",
+	)
+}
+
+func TestInferFunctions(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["identical"], nil, false, nil)
+
+	// structural arguments like positions, intervals, JSON paths and formats are not constants to
+	// be masked, so they are not listed
+	cases := []struct {
+		sql     string
+		columns []string
+	}{
+		{"SELECT * FROM customer WHERE c_since < DATE_ADD('2020-01-01', INTERVAL 3 DAY)", []string{"test.customer.c_since"}},
+		{"SELECT * FROM customer WHERE c_since + INTERVAL 3 DAY > '2020-01-01'", []string{"test.customer.c_since"}},
+		{"SELECT * FROM customer WHERE c_since < DATE '2020-01-01' - INTERVAL '1' YEAR", []string{"test.customer.c_since"}},
+		{"SELECT * FROM customer WHERE JSON_EXTRACT(c_data, '$.a') = 'x'", []string{""}},
+		{"SELECT * FROM customer WHERE c_last LIKE 'abc%'", []string{"test.customer.c_last"}},
+		{"SELECT * FROM customer WHERE c_id + 1 IN (1, 2, 3)", []string{"test.customer.c_id", "", "", ""}},
+		{"SELECT * FROM customer WHERE COALESCE(c_last, 'x') = 'y'", []string{"test.customer.c_last", "test.customer.c_last"}},
+		{"SELECT * FROM customer WHERE IF(c_id > 1, c_last, 'x') = 'y'", []string{"test.customer.c_id", "test.customer.c_last", "test.customer.c_last"}},
+		{"SELECT * FROM customer WHERE CASE WHEN c_id > 1 THEN c_last ELSE 'x' END = 'y'", []string{"test.customer.c_id", "test.customer.c_last", "test.customer.c_last"}},
+		{"SELECT * FROM customer WHERE SUBSTRING(c_last, 1, 3) = 'abc'", []string{"test.customer.c_last"}},
+		{"SELECT * FROM customer WHERE c_last = SUBSTRING('abcdef', 2, 3)", []string{"test.customer.c_last"}},
+		{"SELECT * FROM customer WHERE DATE_FORMAT(c_since, '%Y') = '2020'", []string{""}},
+		{"SELECT * FROM customer WHERE ROUND(c_balance, 1) = 2.5", []string{"test.customer.c_balance"}},
+	}
+	for _, c := range cases {
+		constants, err := w.InferConstants(c.sql)
+		require.Nil(t, err, c.sql)
+		require.Len(t, constants, len(c.columns), c.sql)
+		for i, constant := range constants {
+			require.NotNil(t, constant.Type, "constant `%v` in `%s` not inferred", constant.Value, c.sql)
+			require.Equal(t, c.columns[i], constant.Type.ColumnName(), c.sql)
+		}
+	}
+}
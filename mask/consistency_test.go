@@ -0,0 +1,121 @@
+package mask
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+	"github.com/stretchr/testify/require"
+)
+
+// Collect literal values in a statement in order
+type valueCollector struct {
+	values []string
+}
+
+func (v *valueCollector) Enter(in ast.Node) (ast.Node, bool) {
+	switch expr := in.(type) {
+	case *driver.ValueExpr:
+		s, _ := expr.Datum.ToString()
+		v.values = append(v.values, s)
+	case *ast.UnaryOperationExpr:
+		if value, ok := expr.V.(*driver.ValueExpr); ok && expr.Op == opcode.Minus {
+			s, _ := value.Datum.ToString()
+			v.values = append(v.values, "-"+s)
+			return in, true
+		}
+	}
+	return in, false
+}
+
+func (v *valueCollector) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+func collectValues(t *testing.T, w *DumpWorker, sql string) []string {
+	stmt, err := w.ParseOne(sql)
+	require.Nil(t, err, sql)
+	v := &valueCollector{}
+	stmt.Accept(v)
+	return v.values
+}
+
+// The same value of a column must be masked to the same value, no matter whether it's a literal
+// in SQL text, a parameter of `StmtExecute` event, or a value in data dumps and CSV files
+func TestConsistentMasking(t *testing.T) {
+	t.Parallel()
+
+	maskFunc := MaskFuncMap["workload-sim"]
+	columns := []string{"c_id", "c_last", "c_balance", "c_since", "c_state"}
+	cases := [][]interface{}{
+		{int64(42), "Alice", "-12.5", "2021-10-19 12:34:56", "CA"},
+		{int64(7), "Bob", "100", "2021-10-19", "NY "},
+		{int64(123456), "Carol Smith", "0.01", "1999-01-01 00:00:00.5", "T"},
+	}
+
+	db := newTestDB(t)
+	sqlWorker := NewSQLWorker(db, maskFunc, nil, false, nil)
+	eventWorker := NewEventWorker(newTestDB(t), maskFunc, nil, false, nil)
+	dumpWorker := NewDumpWorker(maskFunc, nil, false, nil)
+	schema := newTestDumpSchema(t, testSchema...)
+	table, ok := schema.tables["test.customer"]
+	require.True(t, ok)
+	csvWorker := NewCSVWorker(table, DefaultCSVFormat(), maskFunc, nil, false, nil)
+
+	conditions := []string{}
+	for _, col := range columns {
+		conditions = append(conditions, col+" = ?")
+	}
+	_, err := eventWorker.PrepareOne(1, "SELECT * FROM customer WHERE "+strings.Join(conditions, " AND "))
+	require.Nil(t, err)
+
+	for _, values := range cases {
+		literals := []string{}
+		csv := []string{strings.Join(columns, ",")}
+		record := []string{}
+		for _, value := range values {
+			literals = append(literals, fmt.Sprintf("'%v'", value))
+			record = append(record, fmt.Sprintf("\"%v\"", value))
+		}
+		csv = append(csv, strings.Join(record, ","))
+
+		// sql
+		conditions := []string{}
+		for i, col := range columns {
+			conditions = append(conditions, fmt.Sprintf("%s = %s", col, literals[i]))
+		}
+		masked, err := sqlWorker.MaskOne("SELECT * FROM customer WHERE " + strings.Join(conditions, " AND "))
+		require.Nil(t, err)
+		fromSQL := collectValues(t, dumpWorker, masked)
+
+		// events
+		params, err := eventWorker.MaskOneExecute(1, values)
+		require.Nil(t, err)
+		fromEvent := []string{}
+		for _, param := range params {
+			fromEvent = append(fromEvent, fmt.Sprintf("%v", param))
+		}
+
+		// dumps
+		masked, err = dumpWorker.MaskOne(fmt.Sprintf("INSERT INTO customer (%s) VALUES (%s)", strings.Join(columns, ","), strings.Join(literals, ",")), schema)
+		require.Nil(t, err)
+		fromDump := collectValues(t, dumpWorker, masked)
+
+		// csv
+		out := &strings.Builder{}
+		require.Nil(t, csvWorker.MaskAll(strings.NewReader(strings.Join(csv, "\n")), out))
+		records := readAllCSV(t, out.String(), DefaultCSVFormat())
+		require.Len(t, records, 2)
+		fromCSV := []string{}
+		for _, field := range records[1] {
+			fromCSV = append(fromCSV, field.Value)
+		}
+
+		require.Equal(t, fromSQL, fromEvent, "%v", values)
+		require.Equal(t, fromSQL, fromDump, "%v", values)
+		require.Equal(t, fromSQL, fromCSV, "%v", values)
+	}
+}
@@ -0,0 +1,292 @@
+package mask
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Format of CSV / TSV data files, the defaults follow Dumpling and Lightning
+type CSVFormat struct {
+	Separator       byte   // field separator
+	Delimiter       byte   // quote character, 0 if fields are never quoted
+	Null            string // representation of NULL in unquoted fields
+	Header          bool   // whether the first row is the header of column names
+	BackslashEscape bool   // whether backslash escapes like `\n` are used in fields
+}
+
+func DefaultCSVFormat() CSVFormat {
+	return CSVFormat{
+		Separator:       ',',
+		Delimiter:       '"',
+		Null:            `\N`,
+		Header:          true,
+		BackslashEscape: true,
+	}
+}
+
+type CSVField struct {
+	Value  string
+	Quoted bool
+	IsNull bool
+}
+
+// Read CSV records in a streaming manner. Quoted fields may contain separators, line breaks
+// and doubled quote characters. Empty lines are skipped.
+type CSVReader struct {
+	reader *bufio.Reader
+	format CSVFormat
+	line   int
+}
+
+func NewCSVReader(r io.Reader, format CSVFormat) *CSVReader {
+	return &CSVReader{
+		reader: bufio.NewReader(r),
+		format: format,
+	}
+}
+
+func unescapeByte(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'b':
+		return '\b'
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 26
+	default:
+		return c
+	}
+}
+
+// The inverse of `unescapeByte` for bytes that must be escaped
+func escapeByte(c byte) byte {
+	switch c {
+	case 0:
+		return '0'
+	case '\n':
+		return 'n'
+	case '\r':
+		return 'r'
+	case '\t':
+		return 't'
+	case 26:
+		return 'Z'
+	default:
+		return c
+	}
+}
+
+// Read one field, returns whether it's the last one of the record
+func (r *CSVReader) readField() (CSVField, bool, error) {
+	value := &bytes.Buffer{}
+	raw := &bytes.Buffer{} // unquoted content before unescaping, for matching NULL
+
+	c, err := r.reader.ReadByte()
+	if err != nil {
+		return CSVField{}, true, err
+	}
+
+	quoted := r.format.Delimiter != 0 && c == r.format.Delimiter
+	if quoted {
+		for {
+			c, err := r.reader.ReadByte()
+			if err == io.EOF {
+				return CSVField{}, true, fmt.Errorf("unterminated quoted field at line %d", r.line)
+			} else if err != nil {
+				return CSVField{}, true, err
+			}
+
+			switch {
+			case c == r.format.Delimiter:
+				next, err := r.reader.Peek(1)
+				if err == nil && next[0] == r.format.Delimiter {
+					_, _ = r.reader.ReadByte()
+					_ = value.WriteByte(c)
+					continue
+				}
+				// the closing quote, content until the next separator is appended as is
+				c, err = r.reader.ReadByte()
+				for err == nil && c != r.format.Separator && c != '\n' {
+					if c != '\r' {
+						_ = value.WriteByte(c)
+					}
+					c, err = r.reader.ReadByte()
+				}
+				if err != nil && err != io.EOF {
+					return CSVField{}, true, err
+				}
+				if c == '\n' {
+					r.line += 1
+				}
+				return CSVField{Value: value.String(), Quoted: true}, err == io.EOF || c == '\n', nil
+			case c == '\\' && r.format.BackslashEscape:
+				next, err := r.reader.ReadByte()
+				if err != nil {
+					return CSVField{}, true, fmt.Errorf("unterminated quoted field at line %d", r.line)
+				}
+				_ = value.WriteByte(unescapeByte(next))
+			default:
+				if c == '\n' {
+					r.line += 1
+				}
+				_ = value.WriteByte(c)
+			}
+		}
+	}
+
+	last := false
+	literalCR := false // whether the last byte is a literal `\r`, which is a part of `\r\n`
+	for {
+		if c == r.format.Separator {
+			break
+		}
+		if c == '\n' {
+			r.line += 1
+			last = true
+			break
+		}
+		_ = raw.WriteByte(c)
+		literalCR = c == '\r'
+		if c == '\\' && r.format.BackslashEscape {
+			next, err := r.reader.ReadByte()
+			if err == nil {
+				_ = raw.WriteByte(next)
+				_ = value.WriteByte(unescapeByte(next))
+			}
+		} else {
+			_ = value.WriteByte(c)
+		}
+
+		c, err = r.reader.ReadByte()
+		if err == io.EOF {
+			last = true
+			break
+		} else if err != nil {
+			return CSVField{}, true, err
+		}
+	}
+
+	if last && literalCR {
+		raw.Truncate(raw.Len() - 1)
+		value.Truncate(value.Len() - 1)
+	}
+	if raw.String() == r.format.Null {
+		return CSVField{IsNull: true}, last, nil
+	}
+	return CSVField{Value: value.String()}, last, nil
+}
+
+// Read the next record, returns `io.EOF` if there's no more records
+func (r *CSVReader) Read() ([]CSVField, error) {
+	// skip empty lines
+	for {
+		next, err := r.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if next[0] != '\n' && next[0] != '\r' {
+			break
+		}
+		_, _ = r.reader.ReadByte()
+		if next[0] == '\n' {
+			r.line += 1
+		}
+	}
+
+	fields := []CSVField{}
+	for {
+		field, last, err := r.readField()
+		if err == io.EOF {
+			// a trailing separator at the end of file
+			fields = append(fields, CSVField{})
+			return fields, nil
+		} else if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+		if last {
+			return fields, nil
+		}
+	}
+}
+
+// Write CSV records in the same format as `CSVReader` reads
+type CSVWriter struct {
+	writer *bufio.Writer
+	format CSVFormat
+}
+
+func NewCSVWriter(w io.Writer, format CSVFormat) *CSVWriter {
+	return &CSVWriter{
+		writer: bufio.NewWriter(w),
+		format: format,
+	}
+}
+
+func (w *CSVWriter) needsQuote(field CSVField) bool {
+	if w.format.Delimiter == 0 {
+		return false
+	}
+	if field.Quoted || field.Value == w.format.Null {
+		return true
+	}
+	if w.format.BackslashEscape {
+		return false
+	}
+	return bytes.ContainsAny([]byte(field.Value), string([]byte{w.format.Separator, w.format.Delimiter, '\n', '\r'}))
+}
+
+func (w *CSVWriter) writeField(field CSVField) {
+	if field.IsNull {
+		_, _ = w.writer.WriteString(w.format.Null)
+		return
+	}
+
+	quoted := w.needsQuote(field)
+	if quoted {
+		_ = w.writer.WriteByte(w.format.Delimiter)
+	}
+	for i := 0; i < len(field.Value); i++ {
+		c := field.Value[i]
+		switch {
+		case quoted && c == w.format.Delimiter:
+			// doubled quote
+			_ = w.writer.WriteByte(c)
+		case !w.format.BackslashEscape:
+		case c == '\\', c == 0, c == 26:
+			_ = w.writer.WriteByte('\\')
+			c = escapeByte(c)
+		case !quoted && (c == '\n' || c == '\r' || c == '\t'):
+			_ = w.writer.WriteByte('\\')
+			c = escapeByte(c)
+		case !quoted && (c == w.format.Separator || c == w.format.Delimiter):
+			_ = w.writer.WriteByte('\\')
+		}
+		_ = w.writer.WriteByte(c)
+	}
+	if quoted {
+		_ = w.writer.WriteByte(w.format.Delimiter)
+	}
+}
+
+func (w *CSVWriter) Write(fields []CSVField) error {
+	for i, field := range fields {
+		if i > 0 {
+			_ = w.writer.WriteByte(w.format.Separator)
+		}
+		w.writeField(field)
+	}
+	return w.writer.WriteByte('\n')
+}
+
+func (w *CSVWriter) Flush() error {
+	return w.writer.Flush()
+}
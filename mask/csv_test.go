@@ -0,0 +1,110 @@
+package mask
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readAllCSV(t *testing.T, text string, format CSVFormat) [][]CSVField {
+	reader := NewCSVReader(strings.NewReader(text), format)
+	records := [][]CSVField{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records
+		}
+		require.Nil(t, err)
+		records = append(records, record)
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	t.Parallel()
+
+	text := "1,\"a,b\",\\N,\"\\N\"\r\n\n2,\"say \"\"hi\"\"\nbye\",a\\,b,\"x\\\"y\"\n3,,\\t,"
+	records := readAllCSV(t, text, DefaultCSVFormat())
+	require.Equal(t, [][]CSVField{
+		{{Value: "1"}, {Value: "a,b", Quoted: true}, {IsNull: true}, {Value: "N", Quoted: true}},
+		{{Value: "2"}, {Value: "say \"hi\"\nbye", Quoted: true}, {Value: "a,b"}, {Value: "x\"y", Quoted: true}},
+		{{Value: "3"}, {}, {Value: "\t"}, {}},
+	}, records)
+
+	tsv := DefaultCSVFormat()
+	tsv.Separator = '\t'
+	tsv.Delimiter = 0
+	records = readAllCSV(t, "a\t\"b\"\t\\N\n", tsv)
+	require.Equal(t, [][]CSVField{{{Value: "a"}, {Value: "\"b\""}, {IsNull: true}}}, records)
+
+	_, err := NewCSVReader(strings.NewReader("\"unterminated\n"), DefaultCSVFormat()).Read()
+	require.NotNil(t, err)
+}
+
+func TestCSVWriterRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	noEscape := DefaultCSVFormat()
+	noEscape.BackslashEscape = false
+	tsv := DefaultCSVFormat()
+	tsv.Separator = '\t'
+
+	record := []CSVField{
+		{Value: "plain"},
+		{Value: "a,b\tc"},
+		{Value: "quote \" and \\ slash"},
+		{Value: "line\nbreak\r"},
+		{Value: `\N`},
+		{IsNull: true},
+		{Value: "quoted", Quoted: true},
+		{},
+	}
+	for _, format := range []CSVFormat{DefaultCSVFormat(), noEscape, tsv} {
+		out := &strings.Builder{}
+		writer := NewCSVWriter(out, format)
+		require.Nil(t, writer.Write(record))
+		require.Nil(t, writer.Flush())
+
+		records := readAllCSV(t, out.String(), format)
+		require.Len(t, records, 1, out.String())
+		require.Len(t, records[0], len(record))
+		for i := range record {
+			require.Equal(t, record[i].IsNull, records[0][i].IsNull, out.String())
+			require.Equal(t, record[i].Value, records[0][i].Value, out.String())
+		}
+	}
+}
+
+func TestCSVWorker(t *testing.T) {
+	t.Parallel()
+
+	schema := newTestDumpSchema(t, "CREATE TABLE t (id INT PRIMARY KEY, name VARCHAR(16), since DATETIME)")
+	table, ok := schema.tables["test.t"]
+	require.True(t, ok)
+	nameMap := NewGlobalNameMap(map[string]string{
+		"test.t.id":    "db0.t0.c0",
+		"test.t.name":  "db0.t0.c1",
+		"test.t.since": "db0.t0.c2",
+	})
+
+	w := NewCSVWorker(table, DefaultCSVFormat(), MaskFuncMap["debug"], nil, true, nameMap)
+	in := "\"since\",\"id\",\"name\"\n\"2021-10-19 12:34:56\",1,\"Alice\"\n\\N,2,\"bad\"\n"
+	out := &strings.Builder{}
+	require.Nil(t, w.MaskAll(strings.NewReader(in), out))
+	require.Equal(t, "\"c2\",\"c0\",\"c1\"\n\"datetime 2021-10-19 12:34:56\",1,\"varchar(16) Alice\"\n\\N,2,\"varchar(16) bad\"\n", out.String())
+	require.Equal(t, uint64(2), w.Stats.Success)
+
+	// cells failed to mask are replaced with `NULL`
+	w = NewCSVWorker(table, DefaultCSVFormat(), MaskFuncMap["debug"], nil, true, nil)
+	out = &strings.Builder{}
+	require.Nil(t, w.MaskAll(strings.NewReader("id,since\n1,secret-date\n"), out))
+	require.Equal(t, "id,since\n1,\\N\n", out.String())
+	require.Equal(t, uint64(1), w.Stats.Problematic)
+
+	w = NewCSVWorker(table, DefaultCSVFormat(), MaskFuncMap["debug"], nil, false, nil)
+	err := w.MaskAll(strings.NewReader("id,unknown\n1,2\n"), &strings.Builder{})
+	require.NotNil(t, err)
+	err = w.MaskAll(strings.NewReader("id,name\n1,a,b\n"), &strings.Builder{})
+	require.NotNil(t, err)
+}
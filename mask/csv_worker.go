@@ -0,0 +1,141 @@
+package mask
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/types"
+)
+
+// Masks CSV / TSV data files of a single table cell by cell with column types of the table,
+// a record is counted as a statement in `Stats`.
+type CSVWorker struct {
+	valueMasker
+	Stats   Stats
+	table   *DumpTable
+	format  CSVFormat
+	nameMap *NameMap
+}
+
+func NewCSVWorker(table *DumpTable, format CSVFormat, maskFunc MaskFunc, policy *MaskPolicy, ignoreIntPK bool, nameMap *NameMap) *CSVWorker {
+	return &CSVWorker{
+		valueMasker: newValueMasker(maskFunc, policy, ignoreIntPK),
+		table:       table,
+		format:      format,
+		nameMap:     nameMap,
+	}
+}
+
+// Map the header to columns of the table, and mask column names if name map is given
+func (w *CSVWorker) maskHeader(header []CSVField) ([]*expression.Column, []CSVField, error) {
+	columns := make([]*expression.Column, 0, len(header))
+	maskedHeader := make([]CSVField, 0, len(header))
+	for _, field := range header {
+		col, ok := w.table.Column(field.Value)
+		if !ok {
+			return nil, nil, fmt.Errorf("column `%s` not found in table `%s`", field.Value, w.table.Name)
+		}
+		columns = append(columns, col)
+
+		if w.nameMap != nil {
+			mapped := strings.Split(w.nameMap.FullColumnName(col.OrigName), ".")
+			field.Value = mapped[len(mapped)-1]
+		}
+		maskedHeader = append(maskedHeader, field)
+	}
+	return columns, maskedHeader, nil
+}
+
+// Mask a record in place, returns errors of cells failed to mask. Cells failed to mask are
+// replaced with `NULL`, so that they never go into the masked file.
+func (w *CSVWorker) maskRecord(record []CSVField, columns []*expression.Column) error {
+	if len(record) != len(columns) {
+		return fmt.Errorf("record has %d fields, but %d columns are expected", len(record), len(columns))
+	}
+
+	errs := MultiError{}
+	for i, field := range record {
+		if field.IsNull {
+			continue
+		}
+		maskedDatum, _, masked, err := w.maskForColumn(types.NewStringDatum(field.Value), columns[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("column `%s`: %w", columns[i].OrigName, err))
+			record[i] = CSVField{IsNull: true}
+			continue
+		}
+		if !masked {
+			continue
+		}
+		if maskedDatum.IsNull() {
+			record[i] = CSVField{IsNull: true}
+			continue
+		}
+		value, err := maskedDatum.ToString()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("column `%s`: %w", columns[i].OrigName, err))
+			record[i] = CSVField{IsNull: true}
+			continue
+		}
+		record[i].Value = value
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Mask all records from `in` and write them to `out`. A record failed to be split into the
+// expected number of fields stops the masking, since the format is likely to be wrong.
+func (w *CSVWorker) MaskAll(in io.Reader, out io.Writer) error {
+	reader := NewCSVReader(in, w.format)
+	writer := NewCSVWriter(out, w.format)
+
+	columns := w.table.Columns
+	if w.format.Header {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return writer.Flush()
+		} else if err != nil {
+			return err
+		}
+		var maskedHeader []CSVField
+		columns, maskedHeader, err = w.maskHeader(header)
+		if err != nil {
+			return err
+		}
+		err = writer.Write(maskedHeader)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		w.Stats.All += 1
+		err = w.maskRecord(record, columns)
+		if _, ok := err.(MultiError); err != nil && !ok {
+			return fmt.Errorf("bad record at line %d; %w", reader.line, err)
+		} else if err != nil {
+			w.Stats.Problematic += 1
+		} else {
+			w.Stats.Success += 1
+		}
+
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
@@ -0,0 +1,354 @@
+package mask
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/auth"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// A literal in a DDL statement, like a `DEFAULT` value, a partition bound or a `CHECK`
+// expression. It's masked as `compared = value` in a `SELECT` on the table, or as the value
+// itself if `compared` is nil, so that its type is inferred as usual.
+type ddlValue struct {
+	compared ast.ExprNode
+	value    *ast.ExprNode
+}
+
+// Collects literals in a DDL statement into `values`, and masks comments in place
+type ddlVisitor struct {
+	masker *valueMasker
+	values []ddlValue
+	errs   MultiError
+}
+
+func (v *ddlVisitor) Enter(in ast.Node) (ast.Node, bool) {
+	switch in := in.(type) {
+	case *ast.CreateTableStmt:
+		v.tableOptions(in.Options)
+	case *ast.AlterTableSpec:
+		v.tableOptions(in.Options)
+		// the partition method is unknown when adding partitions, so bounds are of their own types
+		v.partitions(nil, in.PartDefinitions)
+		if in.Tp == ast.AlterTableAlterColumn {
+			// `ALTER COLUMN c SET DEFAULT x` has an option without type
+			for _, col := range in.NewColumns {
+				for _, opt := range col.Options {
+					if opt.Tp == ast.ColumnOptionNoOption && opt.Expr != nil {
+						v.value(&ast.ColumnNameExpr{Name: &ast.ColumnName{Name: col.Name.Name}}, &opt.Expr)
+					}
+				}
+			}
+		}
+	case *ast.ColumnDef:
+		for _, opt := range in.Options {
+			switch opt.Tp {
+			case ast.ColumnOptionDefaultValue:
+				v.value(&ast.ColumnNameExpr{Name: &ast.ColumnName{Name: in.Name.Name}}, &opt.Expr)
+			case ast.ColumnOptionCheck:
+				v.value(nil, &opt.Expr)
+			case ast.ColumnOptionComment:
+				if expr, ok := opt.Expr.(*driver.ValueExpr); ok {
+					expr.Datum.SetString(v.comment(expr.Datum.GetString()), expr.Datum.Collation())
+				}
+			}
+		}
+	case *ast.Constraint:
+		if in.Tp == ast.ConstraintCheck {
+			v.value(nil, &in.Expr)
+		}
+	case *ast.IndexOption:
+		in.Comment = v.comment(in.Comment)
+	case *ast.PartitionOptions:
+		v.partitions(&in.PartitionMethod, in.Definitions)
+	}
+	return in, false
+}
+
+func (v *ddlVisitor) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+func (v *ddlVisitor) value(compared ast.ExprNode, value *ast.ExprNode) {
+	if _, ok := (*value).(*ast.MaxValueExpr); ok {
+		return
+	}
+	v.values = append(v.values, ddlValue{compared, value})
+}
+
+func (v *ddlVisitor) tableOptions(options []*ast.TableOption) {
+	for _, opt := range options {
+		if opt.Tp == ast.TableOptionComment {
+			opt.StrValue = v.comment(opt.StrValue)
+		}
+	}
+}
+
+// Collect bounds of partitions compared with the expression or columns of `method`
+func (v *ddlVisitor) partitions(method *ast.PartitionMethod, defs []*ast.PartitionDefinition) {
+	compared := func(i int) ast.ExprNode {
+		switch {
+		case method == nil:
+			return nil
+		case method.Expr != nil:
+			return method.Expr
+		case i < len(method.ColumnNames):
+			return &ast.ColumnNameExpr{Name: method.ColumnNames[i]}
+		default:
+			return nil
+		}
+	}
+
+	for _, def := range defs {
+		v.tableOptions(def.Options)
+		switch clause := def.Clause.(type) {
+		case *ast.PartitionDefinitionClauseLessThan:
+			for i := range clause.Exprs {
+				v.value(compared(i), &clause.Exprs[i])
+			}
+		case *ast.PartitionDefinitionClauseIn:
+			for _, values := range clause.Values {
+				for i := range values {
+					v.value(compared(i), &values[i])
+				}
+			}
+		}
+	}
+}
+
+// Mask a comment as a string of its own type
+func (v *ddlVisitor) comment(comment string) string {
+	if comment == "" {
+		return comment
+	}
+	datum := types.NewStringDatum(comment)
+	maskedDatum, _, masked, err := v.masker.mask(datum, ownInferredType(datum))
+	if err != nil {
+		v.errs = append(v.errs, err)
+		return comment
+	}
+	if !masked {
+		return comment
+	}
+	maskedComment, err := maskedDatum.ToString()
+	if err != nil {
+		v.errs = append(v.errs, err)
+		return comment
+	}
+	return maskedComment
+}
+
+// Collects names of tables in a statement
+type tableNameCollector struct {
+	names []*ast.TableName
+}
+
+func (v *tableNameCollector) Enter(in ast.Node) (ast.Node, bool) {
+	if name, ok := in.(*ast.TableName); ok {
+		v.names = append(v.names, name)
+	}
+	return in, false
+}
+
+func (v *tableNameCollector) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+// Collects constraints without names in a statement
+type unnamedConstraintCollector struct {
+	constraints []*ast.Constraint
+}
+
+func (v *unnamedConstraintCollector) Enter(in ast.Node) (ast.Node, bool) {
+	if c, ok := in.(*ast.Constraint); ok && c.Name == "" {
+		v.constraints = append(v.constraints, c)
+	}
+	return in, false
+}
+
+func (v *unnamedConstraintCollector) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+// Columns of tables named `names` which exist in the current schema
+func (w *worker) tableColumns(names []*ast.TableName) []*expression.Column {
+	columns := []*expression.Column{}
+	for _, name := range names {
+		schema := name.Schema.L
+		if schema == "" {
+			schema = strings.ToLower(w.db.CurrentDB())
+		}
+		tblInfo, err := w.db.TableInfo(schema, name.Name.L)
+		if err != nil {
+			continue
+		}
+		for _, col := range tblInfo.Cols() {
+			columns = append(columns, &expression.Column{
+				RetType:  &col.FieldType,
+				OrigName: fmt.Sprintf("%s.%s.%s", schema, tblInfo.Name.L, col.Name.L),
+			})
+		}
+	}
+	return columns
+}
+
+// Mask `values` of a DDL statement on `table` in place, by masking them in a `SELECT` on it
+func (w *worker) maskDDLValues(table *ast.TableName, values []ddlValue) ([]InferredConstant, error) {
+	fields := make([]string, 0, len(values))
+	for _, value := range values {
+		field := fmt.Sprintf("(%s)", restoreNode(*value.value))
+		if value.compared != nil {
+			field = fmt.Sprintf("%s = %s", restoreNode(value.compared), field)
+		}
+		fields = append(fields, field)
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s", strings.Join(fields, ", "), restoreNode(table))
+
+	node, err := w.parseOne(sql)
+	if err != nil {
+		return nil, err
+	}
+	replacedStmtNode, replaced, err := w.replaceValue(node)
+	if err != nil {
+		return nil, err
+	}
+	inferredTypes, _, err := w.infer(replacedStmtNode, replaced)
+	if err != nil {
+		return nil, err
+	}
+	constants := sortedInferredConstants(replaced.OriginExprs, inferredTypes)
+
+	// names are masked later with the DDL statement
+	v := NewRestoreVisitor(replaced.OriginExprs, replaced.Groups, inferredTypes, w.maskFunc, w.policy, nil, w.ignoreIntPK)
+	newNode, ok := replacedStmtNode.Accept(v)
+	if !ok || (v.success == 0 && len(replaced.OriginExprs) > 0) {
+		return constants, fmt.Errorf("failed to restore literals; %v", v.Err())
+	}
+
+	for i, field := range newNode.(*ast.SelectStmt).Fields.Fields {
+		expr := field.Expr
+		if values[i].compared != nil {
+			expr = expr.(*ast.BinaryOperationExpr).R
+		}
+		*values[i].value = expr.(*ast.ParenthesesExpr).Expr
+	}
+	return constants, v.Err()
+}
+
+// Execute a DDL statement to keep the schema current, then mask names, literals and comments
+// in it. Like `maskOneQuery`, a non-empty result with an error is problematic.
+func (w *worker) maskDDL(node ast.DDLNode) (string, []InferredConstant, error) {
+	tables := &tableNameCollector{}
+	node.Accept(tables)
+	// columns may be dropped or added by the statement
+	columns := w.tableColumns(tables.names)
+	// the definer of a view is resolved to the session user during execution, keep it as is
+	var definer *auth.UserIdentity
+	if view, ok := node.(*ast.CreateViewStmt); ok && view.Definer != nil {
+		d := *view.Definer
+		definer = &d
+	}
+
+	// names of unnamed constraints are filled in during execution, keep them unnamed
+	unnamed := &unnamedConstraintCollector{}
+	node.Accept(unnamed)
+
+	_, err := w.db.ExecuteOneStmt(node)
+	if err != nil {
+		return "", nil, executeError(node, err)
+	}
+	columns = mergeColumns(columns, w.tableColumns(tables.names))
+	for _, c := range unnamed.constraints {
+		c.Name = ""
+	}
+	if view, ok := node.(*ast.CreateViewStmt); ok {
+		view.Definer = definer
+		return w.maskView(view)
+	}
+
+	v := &ddlVisitor{masker: &w.valueMasker}
+	node.Accept(v)
+	errs := v.errs
+
+	var constants []InferredConstant
+	if len(v.values) > 0 {
+		var table *ast.TableName
+		switch node := node.(type) {
+		case *ast.CreateTableStmt:
+			table = node.Table
+		case *ast.AlterTableStmt:
+			table = node.Table
+		}
+		if table == nil {
+			return "", nil, fmt.Errorf("unsupported literals in `%s` statement", stmtKind(node))
+		}
+
+		constants, err = w.maskDDLValues(table, v.values)
+		if _, ok := err.(MultiError); err != nil && !ok {
+			return "", nil, fmt.Errorf("failed to mask literals in `%s` statement; %w", stmtKind(node), err)
+		}
+		if multi, ok := err.(MultiError); ok {
+			errs = append(errs, multi...)
+		}
+	}
+
+	var newNode ast.Node = node
+	if w.globalNameMap != nil {
+		localNameMap, err := NewLocalNameMap(w.globalNameMap, columns, w.db.CurrentDB())
+		if err != nil {
+			return "", nil, err
+		}
+		mapDatabaseName(node, localNameMap)
+		newNode, _ = node.Accept(NewNameOnlyRestoreVisitor(localNameMap))
+		if err := w.checkUnmapped(localNameMap); err != nil {
+			return "", nil, err
+		}
+	}
+	newSQL, err := w.db.RestoreSQL(newNode)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(errs) > 0 {
+		return fmt.Sprintf("/* PROBLEMATIC: %v */ %s", errs, newSQL), constants, errs
+	}
+	return newSQL, constants, nil
+}
+
+// Mask a view after it's created, whose `SELECT` is masked like a query
+func (w *worker) maskView(view *ast.CreateViewStmt) (string, []InferredConstant, error) {
+	replacedStmtNode, replaced, err := w.replaceValue(view)
+	if err != nil {
+		return "", nil, err
+	}
+	inferredTypes, localNameMap, err := w.infer(replacedStmtNode.(*ast.CreateViewStmt).Select, replaced)
+	if err != nil {
+		return "", nil, err
+	}
+	constants := sortedInferredConstants(replaced.OriginExprs, inferredTypes)
+
+	newSQL, err := w.restore(replacedStmtNode, replaced, inferredTypes, localNameMap)
+	if unmappedErr := w.checkUnmapped(localNameMap); unmappedErr != nil {
+		return "", constants, unmappedErr
+	}
+	if err != nil && newSQL != "" { // problematic
+		newSQL = fmt.Sprintf("/* PROBLEMATIC: %v */ %s", err, newSQL)
+	}
+	return newSQL, constants, err
+}
+
+// Map names of databases in database statements
+func mapDatabaseName(node ast.DDLNode, nameMap *NameMap) {
+	switch node := node.(type) {
+	case *ast.CreateDatabaseStmt:
+		node.Name = nameMap.DB(node.Name)
+	case *ast.DropDatabaseStmt:
+		node.Name = nameMap.DB(node.Name)
+	case *ast.AlterDatabaseStmt:
+		node.Name = nameMap.DB(node.Name)
+	}
+}
@@ -0,0 +1,111 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskDDL(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+
+	cases := []struct {
+		sql    string
+		masked string
+	}{
+		{
+			"CREATE TABLE ddl_people (id INT PRIMARY KEY DEFAULT 5 COMMENT 'the id', name VARCHAR(20) DEFAULT 'bob', CONSTRAINT ck CHECK (id > 10)) COMMENT 'people' PARTITION BY RANGE (id) (PARTITION p0 VALUES LESS THAN (100), PARTITION p1 VALUES LESS THAN MAXVALUE)",
+			"CREATE TABLE `test`.`ddl_people` (`id` INT(11) PRIMARY KEY DEFAULT 'int(11) 5' COMMENT 'var_string(5) the id',`name` VARCHAR(20) CHARACTER SET UTF8MB4 COLLATE utf8mb4_bin DEFAULT 'varchar(20) bob',CONSTRAINT `ck` CHECK(`id`>'int(11) 10') ENFORCED) COMMENT = 'var_string(5) people' PARTITION BY RANGE (`id`) (PARTITION `p0` VALUES LESS THAN ('int(11) 100'),PARTITION `p1` VALUES LESS THAN (MAXVALUE))",
+		},
+		{
+			"ALTER TABLE ddl_people ALTER COLUMN name SET DEFAULT 'alice'",
+			"ALTER TABLE `test`.`ddl_people` ALTER COLUMN `name` SET DEFAULT 'varchar(20) alice'",
+		},
+		{
+			"CREATE INDEX idx_name ON ddl_people (name) COMMENT 'by name'",
+			"CREATE INDEX `idx_name` ON `test`.`ddl_people` (`name`) COMMENT 'var_string(5) by name'",
+		},
+		{
+			"CREATE TABLE ddl_ranges (a INT, b DATE) PARTITION BY RANGE COLUMNS (a, b) (PARTITION p0 VALUES LESS THAN (10, '2020-01-01'))",
+			"CREATE TABLE `test`.`ddl_ranges` (`a` INT(11),`b` DATE(10)) PARTITION BY RANGE COLUMNS (`a`,`b`) (PARTITION `p0` VALUES LESS THAN ('int(11) 10', 'date 2020-01-01'))",
+		},
+	}
+	for _, c := range cases {
+		result := w.MaskOneResult(c.sql)
+		require.Equal(t, StatusSuccess, result.Status, c.sql)
+		require.Equal(t, c.masked, result.Masked)
+	}
+
+	// the statement is still executed, so that following ones see the new schema
+	result := w.MaskOneResult("SELECT * FROM ddl_people WHERE name = 'bob'")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, []string{"test.ddl_people.name"}, result.Constants[0].Columns)
+}
+
+func TestMaskDDLErrors(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+
+	// errors never contain the statements with values
+	result := w.MaskOneResult("CREATE TABLE ddl_bad_default (a DATETIME DEFAULT 'SECRET6')")
+	require.Equal(t, StatusFailed, result.Status)
+	require.Equal(t, []string{"failed to execute `CreateTable` statement; error 1067"}, result.Errors)
+
+	result = w.MaskOneResult("CREATE TABLE ddl_bad_check (a INT CHECK (a > 'SECRET7' + no_such_col))")
+	require.Equal(t, StatusFailed, result.Status)
+	require.Len(t, result.Errors, 1)
+	require.NotContains(t, result.Errors[0], "SECRET7")
+}
+
+func TestMaskDDLNames(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	nameMap := NewGlobalNameMap(map[string]string{
+		"test.ddl_named.id":   "db0.table9.col0",
+		"test.ddl_named.name": "db0.table9.col1",
+	})
+	nameMap.Indexes["test.ddl_named.idx_name"] = "db0.table9.idx0"
+	nameMap.Variables["named_id"] = "var0"
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nameMap)
+
+	result := w.MaskOneResult("CREATE TABLE ddl_named (id INT, name VARCHAR(20) CHECK (name <> 'x'), KEY idx_name (name))")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "CREATE TABLE `db0`.`table9` (`col0` INT(11),`col1` VARCHAR(20) CHARACTER SET UTF8MB4 COLLATE utf8mb4_bin CHECK(`col1`!='varchar(20) x') ENFORCED,INDEX `idx0`(`col1`))", result.Masked)
+
+	// as well as indexes in hints and user variables
+	result = w.MaskOneResult("SET @named_id = 1")
+	require.Equal(t, "SET @`var0`='bigint(20) 1'", result.Masked)
+	result = w.MaskOneResult("SET NAMES utf8mb4")
+	require.Equal(t, "SET NAMES utf8mb4", result.Masked)
+	result = w.MaskOneResult("SELECT name FROM ddl_named USE INDEX (idx_name) WHERE id = @named_id")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "SELECT `col1` FROM `db0`.`table9` USE INDEX (`idx0`) WHERE `col0`=@`var0`", result.Masked)
+
+	// names of dropped tables and columns are mapped as well
+	result = w.MaskOneResult("ALTER TABLE ddl_named DROP COLUMN name")
+	require.Equal(t, "ALTER TABLE `db0`.`table9` DROP COLUMN `col1`", result.Masked)
+	result = w.MaskOneResult("DROP TABLE ddl_named")
+	require.Equal(t, "DROP TABLE `db0`.`table9`", result.Masked)
+}
+
+func TestMaskView(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+
+	result := w.MaskOneResult("CREATE VIEW ddl_bobs AS SELECT c_id, c_last FROM customer WHERE c_last = 'bob' AND c_id > 3")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "CREATE ALGORITHM = UNDEFINED DEFINER = CURRENT_USER SQL SECURITY DEFINER VIEW `test`.`ddl_bobs` (`c_id`,`c_last`) AS SELECT `c_id` AS `c_id`,`c_last` AS `c_last` FROM `test`.`customer` WHERE `c_last`='varchar(16) bob' AND `c_id`>'int(11) 3'", result.Masked)
+
+	// columns of views are inferred from the underlying tables
+	result = w.MaskOneResult("SELECT * FROM ddl_bobs WHERE c_id = 5")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, []string{"test.customer.c_id"}, result.Constants[0].Columns)
+}
@@ -0,0 +1,344 @@
+package mask
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/opcode"
+	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// A table learned from `CREATE TABLE` in a dump
+type DumpTable struct {
+	Name    string // `db.table` in lower case
+	Columns []*expression.Column
+	offsets map[string]int
+}
+
+func (t *DumpTable) Column(name string) (*expression.Column, bool) {
+	offset, ok := t.offsets[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	return t.Columns[offset], true
+}
+
+// Build a table from `CREATE TABLE`, `db` is used if the table name is not qualified
+func NewDumpTable(db string, stmt *ast.CreateTableStmt) (*DumpTable, error) {
+	tblInfo, err := ddl.BuildTableInfoFromAST(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s.%s", db, tblInfo.Name.L)
+	if stmt.Table.Schema.L != "" {
+		name = fmt.Sprintf("%s.%s", stmt.Table.Schema.L, tblInfo.Name.L)
+	}
+	table := &DumpTable{
+		Name:    name,
+		offsets: make(map[string]int),
+	}
+
+	// only a single integer primary key is considered as the int handle, like what planner does
+	pkCols := 0
+	for _, col := range tblInfo.Cols() {
+		if mysql.HasPriKeyFlag(col.Flag) {
+			pkCols += 1
+		}
+	}
+	for i, col := range tblInfo.Cols() {
+		ft := col.FieldType.Clone()
+		if mysql.HasPriKeyFlag(ft.Flag) && (pkCols != 1 || ft.EvalType() != types.ETInt) {
+			ft.Flag &^= mysql.PriKeyFlag
+		}
+		table.Columns = append(table.Columns, &expression.Column{
+			RetType:  ft,
+			OrigName: fmt.Sprintf("%s.%s", name, col.Name.L),
+		})
+		table.offsets[col.Name.L] = i
+	}
+
+	return table, nil
+}
+
+// Column types of tables learned from `CREATE TABLE` statements in a dump.
+//
+// A `DumpSchema` is never modified once created, `Learn` returns a new one instead, so that
+// it can be shared with workers masking statements concurrently.
+type DumpSchema struct {
+	currentDB string
+	tables    map[string]*DumpTable
+}
+
+func NewDumpSchema(defaultDB string) *DumpSchema {
+	return &DumpSchema{
+		currentDB: strings.ToLower(defaultDB),
+		tables:    make(map[string]*DumpTable),
+	}
+}
+
+func (s *DumpSchema) clone() *DumpSchema {
+	tables := make(map[string]*DumpTable, len(s.tables))
+	for k, v := range s.tables {
+		tables[k] = v
+	}
+	return &DumpSchema{
+		currentDB: s.currentDB,
+		tables:    tables,
+	}
+}
+
+func (s *DumpSchema) tableKey(name *ast.TableName) string {
+	if name.Schema.L != "" {
+		return fmt.Sprintf("%s.%s", name.Schema.L, name.Name.L)
+	}
+	return fmt.Sprintf("%s.%s", s.currentDB, name.Name.L)
+}
+
+func (s *DumpSchema) Table(name *ast.TableName) (*DumpTable, bool) {
+	table, ok := s.tables[s.tableKey(name)]
+	return table, ok
+}
+
+// Learn from `USE`, `CREATE TABLE` and `DROP TABLE` statements, returns the new schema
+func (s *DumpSchema) Learn(stmt ast.StmtNode) (*DumpSchema, error) {
+	switch stmt := stmt.(type) {
+	case *ast.UseStmt:
+		newSchema := s.clone()
+		newSchema.currentDB = strings.ToLower(stmt.DBName)
+		return newSchema, nil
+
+	case *ast.CreateTableStmt:
+		table, err := NewDumpTable(s.currentDB, stmt)
+		if err != nil {
+			return s, err
+		}
+		newSchema := s.clone()
+		newSchema.tables[table.Name] = table
+		return newSchema, nil
+
+	case *ast.DropTableStmt:
+		newSchema := s.clone()
+		for _, name := range stmt.Tables {
+			delete(newSchema.tables, s.tableKey(name))
+		}
+		return newSchema, nil
+
+	default:
+		return s, nil
+	}
+}
+
+// Masks `INSERT` statements in data dumps like `mysqldump` output. Values are masked column by
+// column with the types in `DumpSchema`, without compiling plans, so it's much faster than
+// `SQLWorker` for huge dumps. Each worker owns a parser, so use one worker per goroutine.
+type DumpWorker struct {
+	valueMasker
+	Stats   Stats
+	parser  *parser.Parser
+	nameMap *NameMap
+}
+
+func NewDumpWorker(maskFunc MaskFunc, policy *MaskPolicy, ignoreIntPK bool, nameMap *NameMap) *DumpWorker {
+	return &DumpWorker{
+		valueMasker: newValueMasker(maskFunc, policy, ignoreIntPK),
+		parser:      parser.New(),
+		nameMap:     nameMap,
+	}
+}
+
+func (w *DumpWorker) ParseOne(sql string) (ast.StmtNode, error) {
+	return w.parser.ParseOneStmt(sql, "", "")
+}
+
+// Mask a literal value `expr` for `col`. A value failed to mask is replaced with `NULL`, so that
+// it never goes into the masked dump.
+func (w *DumpWorker) maskValue(expr ast.ExprNode, col *expression.Column) (ast.ExprNode, error) {
+	null := ast.NewValueExpr(nil, "", "")
+	var datum types.Datum
+	switch e := expr.(type) {
+	case *driver.ValueExpr:
+		datum = e.Datum
+	case *ast.UnaryOperationExpr:
+		// negative numbers like `-42`
+		v, ok := e.V.(*driver.ValueExpr)
+		if !ok || e.Op != opcode.Minus {
+			return null, fmt.Errorf("value for column `%s` is not a literal", col.OrigName)
+		}
+		s, err := v.Datum.ToString()
+		if err != nil {
+			return null, fmt.Errorf("column `%s`: %w", col.OrigName, err)
+		}
+		datum = types.NewStringDatum("-" + s)
+	case *ast.DefaultExpr:
+		return expr, nil
+	default:
+		return null, fmt.Errorf("value for column `%s` is not a literal", col.OrigName)
+	}
+
+	if datum.IsNull() {
+		return expr, nil
+	}
+
+	maskedDatum, maskedType, masked, err := w.maskForColumn(datum, col)
+	if err != nil {
+		return null, fmt.Errorf("column `%s`: %w", col.OrigName, err)
+	}
+	if !masked {
+		return expr, nil
+	}
+	maskedExpr := ast.NewValueExpr(maskedDatum.GetValue(), "", "")
+	maskedExpr.SetType(maskedType)
+	return maskedExpr, nil
+}
+
+func (w *DumpWorker) maskInsert(stmt *ast.InsertStmt, schema *DumpSchema) error {
+	if stmt.Select != nil {
+		return fmt.Errorf("`INSERT ... SELECT` is not supported in dumps")
+	}
+	source, ok := stmt.Table.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return fmt.Errorf("unsupported insert target")
+	}
+	tableName, ok := source.Source.(*ast.TableName)
+	if !ok {
+		return fmt.Errorf("unsupported insert target")
+	}
+	table, ok := schema.Table(tableName)
+	if !ok {
+		return fmt.Errorf("table `%s` not found in dump schema", schema.tableKey(tableName))
+	}
+
+	columns := table.Columns
+	if len(stmt.Columns) > 0 {
+		columns = make([]*expression.Column, 0, len(stmt.Columns))
+		for _, name := range stmt.Columns {
+			col, ok := table.Column(name.Name.L)
+			if !ok {
+				return fmt.Errorf("column `%s` not found in table `%s`", name.Name.O, table.Name)
+			}
+			columns = append(columns, col)
+		}
+	}
+
+	errs := MultiError{}
+	for i, list := range stmt.Lists {
+		if len(list) != len(columns) {
+			return fmt.Errorf("row %d has %d values, but %d columns are expected", i+1, len(list), len(columns))
+		}
+		for j, expr := range list {
+			masked, err := w.maskValue(expr, columns[j])
+			if err != nil {
+				errs = append(errs, err)
+			}
+			list[j] = masked
+		}
+	}
+
+	assignments := append([]*ast.Assignment{}, stmt.Setlist...)
+	assignments = append(assignments, stmt.OnDuplicate...)
+	for _, assignment := range assignments {
+		col, ok := table.Column(assignment.Column.Name.L)
+		if !ok {
+			return fmt.Errorf("column `%s` not found in table `%s`", assignment.Column.Name.O, table.Name)
+		}
+		// `VALUES(col)` refers to the value inserted, which is already masked
+		if _, isValues := assignment.Expr.(*ast.ValuesExpr); isValues {
+			continue
+		}
+		masked, err := w.maskValue(assignment.Expr, col)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		assignment.Expr = masked
+	}
+
+	if w.nameMap != nil {
+		w.mapInsertNames(stmt, tableName, table)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Map names of the table and columns of an `INSERT` into `table` by the name map
+func (w *DumpWorker) mapInsertNames(stmt *ast.InsertStmt, tableName *ast.TableName, table *DumpTable) {
+	mapped := strings.Split(w.nameMap.FullTableName(table.Name), ".")
+	tableName.Name = model.NewCIStr(mapped[len(mapped)-1])
+	if tableName.Schema.L != "" {
+		tableName.Schema = model.NewCIStr(mapped[0])
+	}
+
+	mapColumn := func(name *ast.ColumnName) {
+		col, ok := table.Column(name.Name.L)
+		if !ok {
+			return
+		}
+		mapped := strings.Split(w.nameMap.FullColumnName(col.OrigName), ".")
+		name.Name = model.NewCIStr(mapped[len(mapped)-1])
+		name.Table, name.Schema = model.CIStr{}, model.CIStr{}
+	}
+	for _, name := range stmt.Columns {
+		mapColumn(name)
+	}
+	for _, assignment := range stmt.Setlist {
+		mapColumn(assignment.Column)
+	}
+	for _, assignment := range stmt.OnDuplicate {
+		mapColumn(assignment.Column)
+		if values, ok := assignment.Expr.(*ast.ValuesExpr); ok {
+			mapColumn(values.Column.Name)
+		}
+	}
+}
+
+// Mask one `INSERT` or `REPLACE` statement in a dump based on `schema`, other statements fail
+// and should be masked by `SQLWorker`. Like `SQLWorker`, a non-empty result with an error is
+// problematic.
+//
+// Returned errors never contain values in `sql`, so they can be written to the masked dump.
+func (w *DumpWorker) MaskOne(sql string, schema *DumpSchema) (string, error) {
+	w.Stats.All += 1
+
+	stmt, err := w.ParseOne(sql)
+	if err != nil {
+		return "", errParse
+	}
+	insert, ok := stmt.(*ast.InsertStmt)
+	if !ok {
+		return "", fmt.Errorf("`%s` statement is not supported by dump workers", stmtKind(stmt))
+	}
+
+	maskErr := w.maskInsert(insert, schema)
+	if _, ok := maskErr.(MultiError); maskErr != nil && !ok {
+		return "", fmt.Errorf("insert into %s: %w", restoreNode(insert.Table.TableRefs), maskErr)
+	}
+
+	newSQL := restoreNode(insert)
+	if maskErr != nil {
+		w.Stats.Problematic += 1
+		return fmt.Sprintf("/* PROBLEMATIC: %v */ %s", maskErr, newSQL), maskErr
+	}
+	w.Stats.Success += 1
+	return newSQL, nil
+}
+
+func restoreNode(node ast.Node) string {
+	buf := &strings.Builder{}
+	restoreFlags := format.DefaultRestoreFlags | format.RestoreStringWithoutDefaultCharset
+	err := node.Restore(format.NewRestoreCtx(restoreFlags, buf))
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,134 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDumpSchema(t *testing.T, sqls ...string) *DumpSchema {
+	w := NewDumpWorker(MaskFuncMap["identical"], nil, false, nil)
+	schema := NewDumpSchema("test")
+	for _, sql := range sqls {
+		stmt, err := w.ParseOne(sql)
+		require.Nil(t, err)
+		schema, err = schema.Learn(stmt)
+		require.Nil(t, err)
+	}
+	return schema
+}
+
+func TestDumpSchemaLearn(t *testing.T) {
+	t.Parallel()
+
+	schema := newTestDumpSchema(t,
+		"CREATE TABLE t (id INT PRIMARY KEY, name VARCHAR(16))",
+		"USE other",
+		"CREATE TABLE t (a BIGINT, b INT, PRIMARY KEY (a, b))",
+	)
+	require.Len(t, schema.tables, 2)
+
+	table := schema.tables["test.t"]
+	require.NotNil(t, table)
+	id, ok := table.Column("ID")
+	require.True(t, ok)
+	require.True(t, NewColumnInferredType(id).IsPrimaryKey())
+
+	// composite primary keys are not int handles
+	a, ok := schema.tables["other.t"].Column("a")
+	require.True(t, ok)
+	require.False(t, NewColumnInferredType(a).IsPrimaryKey())
+
+	w := NewDumpWorker(MaskFuncMap["identical"], nil, false, nil)
+	stmt, err := w.ParseOne("DROP TABLE IF EXISTS test.t")
+	require.Nil(t, err)
+	dropped, err := schema.Learn(stmt)
+	require.Nil(t, err)
+	require.Len(t, dropped.tables, 1)
+	require.Len(t, schema.tables, 2) // schemas are never modified
+}
+
+func TestDumpWorkerMaskOne(t *testing.T) {
+	t.Parallel()
+
+	schema := newTestDumpSchema(t, "CREATE TABLE t (id INT PRIMARY KEY, name VARCHAR(16), balance DECIMAL(6, 2), since DATETIME)")
+	w := NewDumpWorker(MaskFuncMap["debug"], nil, true, nil)
+
+	cases := []struct {
+		sql    string
+		masked string
+	}{
+		{
+			"INSERT INTO `t` VALUES (1,'Alice',-12.50,'2021-10-19 12:34:56'),(2,'Bob',NULL,DEFAULT)",
+			"INSERT INTO `t` VALUES (1,'varchar(16) Alice','decimal(6,2) -12.50','datetime 2021-10-19 12:34:56'),(2,'varchar(16) Bob',NULL,DEFAULT)",
+		},
+		{
+			"INSERT INTO t (name, id) VALUES ('Carol', 3)",
+			"INSERT INTO `t` (`name`,`id`) VALUES ('varchar(16) Carol',3)",
+		},
+	}
+	for _, c := range cases {
+		masked, err := w.MaskOne(c.sql, schema)
+		require.Nil(t, err, c.sql)
+		require.Equal(t, c.masked, masked)
+	}
+
+	// other statements are masked by `SQLWorker`
+	masked, err := w.MaskOne("LOCK TABLES `t` WRITE", schema)
+	require.Empty(t, masked)
+	require.Equal(t, "`LockTables` statement is not supported by dump workers", err.Error())
+
+	_, err = w.MaskOne("INSERT INTO unknown VALUES (1)", schema)
+	require.NotNil(t, err)
+	_, err = w.MaskOne("INSERT INTO t (id) VALUES (1, 2)", schema)
+	require.NotNil(t, err)
+
+	masked, err = w.MaskOne("INSERT INTO t (id, name) VALUES (1, CONCAT('a', 'b'))", schema)
+	require.NotNil(t, err)
+	require.Contains(t, masked, "PROBLEMATIC")
+
+	require.Equal(t, uint64(6), w.Stats.All)
+	require.Equal(t, uint64(2), w.Stats.Success)
+	require.Equal(t, uint64(1), w.Stats.Problematic)
+}
+
+func TestDumpWorkerKeepValuesOnError(t *testing.T) {
+	t.Parallel()
+
+	schema := newTestDumpSchema(t, "CREATE TABLE t (id INT PRIMARY KEY, name VARCHAR(16), since DATETIME)")
+	w := NewDumpWorker(MaskFuncMap["workload-sim"], nil, false, nil)
+
+	// cells failed to mask are replaced with `NULL`
+	masked, err := w.MaskOne("INSERT INTO t VALUES (1, CONCAT('secret-1'), 'secret-2')", schema)
+	require.NotNil(t, err)
+	require.NotContains(t, masked, "secret")
+	require.Regexp(t, `VALUES \(-?\d+,NULL,NULL\)$`, masked)
+
+	// errors of failed statements are written to the dump instead
+	_, err = w.MaskOne("INSERT INTO t VALUES (1, 'secret-3", schema)
+	require.NotNil(t, err)
+	require.NotContains(t, err.Error(), "secret")
+	_, err = w.MaskOne("INSERT INTO t VALUES (1, 'secret-4')", schema)
+	require.NotNil(t, err)
+	require.NotContains(t, err.Error(), "secret")
+	require.Contains(t, err.Error(), "`t`")
+}
+
+func TestDumpWorkerNameMap(t *testing.T) {
+	t.Parallel()
+
+	schema := newTestDumpSchema(t, "CREATE TABLE t (id INT PRIMARY KEY, name VARCHAR(16))")
+	nameMap := NewGlobalNameMap(map[string]string{
+		"test.t.id":   "db0.table0.col0",
+		"test.t.name": "db0.table0.col1",
+	})
+	w := NewDumpWorker(MaskFuncMap["debug"], nil, false, nameMap)
+
+	masked, err := w.MaskOne("INSERT INTO t (id, name) VALUES (1, 'a') ON DUPLICATE KEY UPDATE name = VALUES(name)", schema)
+	require.Nil(t, err)
+	require.Equal(t, "INSERT INTO `table0` (`col0`,`col1`) VALUES ('int(11) 1','varchar(16) a') ON DUPLICATE KEY UPDATE `col1`=VALUES(`col1`)", masked)
+
+	masked, err = w.MaskOne("INSERT INTO test.t SET id = 2", schema)
+	require.Nil(t, err)
+	require.Equal(t, "INSERT INTO `db0`.`table0` SET `col0`='int(11) 2'", masked)
+}
@@ -2,9 +2,9 @@ package mask
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/BugenZhao/sql-masker/tidb"
-	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/types"
 	"github.com/zyguan/mysql-replay/event"
 )
@@ -13,6 +13,10 @@ type Prepared struct {
 	sql           string
 	typeMap       TypeMap
 	sortedMarkers []ReplaceMarker
+	// params in structural arguments of functions, which are never masked
+	structural map[ReplaceMarker]bool
+	// params used as patterns, like `LIKE ?`
+	patterns map[ReplaceMarker]Pattern
 }
 
 type PreparedMap = map[uint64]Prepared
@@ -23,27 +27,27 @@ type EventWorker struct {
 }
 
 // Create a mask worker for MySQL Events
-func NewEventWorker(db *tidb.Context, maskFunc MaskFunc, ignoreIntPK bool, nameMap *NameMap) *EventWorker {
+func NewEventWorker(db *tidb.Context, maskFunc MaskFunc, policy *MaskPolicy, ignoreIntPK bool, nameMap *NameMap) *EventWorker {
 	return &EventWorker{
-		worker:        *newWorker(db, maskFunc, ignoreIntPK, nameMap),
+		worker:        *newWorker(db, maskFunc, policy, ignoreIntPK, nameMap),
 		preparedStmts: make(PreparedMap),
 	}
 }
 
 // For type `StmtPrepare`, do not evaluate but only analyze it and store into `w.preparedStmts`
 func (w *EventWorker) PrepareOne(stmtID uint64, sql string) (string, error) {
-	replacedStmtNode, sortedMarkers, err := w.replaceParamMarker(sql)
+	replacedStmtNode, sortedMarkers, replaced, err := w.replaceParamMarker(sql)
 	if err != nil {
 		return "", err
 	}
-	inferredTypes, localNameMap, err := w.infer(replacedStmtNode)
+	inferredTypes, localNameMap, err := w.infer(replacedStmtNode, replaced)
 	if err != nil {
 		return "", err
 	}
 
 	newSQL := sql
 	if localNameMap != nil {
-		stmtNode, err := w.db.ParseOne(sql)
+		stmtNode, err := w.parseOne(sql)
 		if err != nil {
 			return "", err
 		}
@@ -51,7 +55,10 @@ func (w *EventWorker) PrepareOne(stmtID uint64, sql string) (string, error) {
 
 		newNode, ok := stmtNode.Accept(v)
 		if !ok {
-			return "", v.err
+			return "", v.Err()
+		}
+		if err := w.checkUnmapped(localNameMap); err != nil {
+			return "", err
 		}
 		newSQL, err = w.db.RestoreSQL(newNode)
 		if err != nil {
@@ -60,7 +67,7 @@ func (w *EventWorker) PrepareOne(stmtID uint64, sql string) (string, error) {
 	}
 
 	w.preparedStmts[stmtID] = Prepared{
-		sql, inferredTypes, sortedMarkers,
+		sql, inferredTypes, sortedMarkers, replaced.Structural, replaced.Patterns,
 	}
 	return newSQL, nil
 }
@@ -73,57 +80,55 @@ func (w *EventWorker) MaskOneExecute(stmtID uint64, params []interface{}) ([]int
 	}
 
 	if len(p.sortedMarkers) != len(params) {
-		return params, fmt.Errorf("mismatched length of inferred markers and params for stmt `%s`", p.sql)
+		return params, fmt.Errorf("mismatched length of inferred markers and params for stmt id `%d`", stmtID)
 	}
 
-	sc := &stmtctx.StatementContext{}
 	maskedParams := []interface{}{}
-	var err error
+	var errs MultiError
 
 	for i, param := range params {
 		originDatum := types.NewDatum(param)
-
-		marker := p.sortedMarkers[i]
-		// HACK: Most of constants in `PREPARE` statements are not considered to be masked.
-		//       In replace phase, we have replaced all constants with `1`, so this may help
-		//       to handle expressions like `? +/-/*/div {constant}`
-		possibleMarkers := []ReplaceMarker{
-			marker,
-			marker + 1,
-			marker - 1,
+		if p.structural[p.sortedMarkers[i]] {
+			maskedParams = append(maskedParams, param)
+			continue
 		}
 
-		var tp *InferredType
-		for _, marker := range possibleMarkers {
-			tp, ok = p.typeMap[marker]
-			if ok {
-				break
-			}
-		}
-		if tp == nil {
-			err = fmt.Errorf("type for `%v` not inferred; %w", originDatum, err)
-			maskedParams = append(maskedParams, originDatum)
-			continue
+		// params in expressions like `? + 1` are grouped and share the inferred type
+		// params lost in implicit casts are masked with their own types, see `markerOf`
+		tp, ok := p.typeMap[p.sortedMarkers[i]]
+		if !ok {
+			tp = ownInferredType(originDatum)
+			errs = append(errs, fmt.Errorf("type for constant %d not inferred", i+1))
 		}
 
+		// use original datum if not masked, e.g., int pk is ignored
 		var maskedDatum types.Datum
-		if tp.IsPrimaryKey() && w.ignoreIntPK {
-			// use original datum if int pk is ignored
-			maskedDatum = originDatum
+		var maskErr error
+		if pattern, ok := p.patterns[p.sortedMarkers[i]]; ok {
+			maskedDatum, _, _, maskErr = w.maskPattern(originDatum, tp, pattern)
 		} else {
-			maskedDatum, _, err = ConvertAndMask(sc, originDatum, tp.Ft, w.maskFunc)
-			if err != nil {
-				return params, err
-			}
+			maskedDatum, _, _, maskErr = w.mask(originDatum, tp)
+		}
+		if maskErr != nil {
+			return params, maskErr
+		}
+		if tp.IsAmbiguous() {
+			errs = append(errs, &AmbiguousTypeError{i + 1, tp})
 		}
 
 		maskedParam := datumToEventParam(maskedDatum)
 		maskedParams = append(maskedParams, maskedParam)
 	}
 
+	// ambiguous and not inferred types are reported as `MultiError`
+	if len(errs) > 0 {
+		return maskedParams, errs
+	}
 	return maskedParams, nil
 }
 
+// Mask an event. Failed queries and prepared statements are replaced with a comment of errors,
+// and parameters of failed executions with `NULL`s, so that no unmasked event is returned.
 func (w *EventWorker) MaskOne(ev event.MySQLEvent) (event.MySQLEvent, error) {
 	w.Stats.All += 1
 
@@ -136,14 +141,14 @@ func (w *EventWorker) MaskOne(ev event.MySQLEvent) (event.MySQLEvent, error) {
 		}
 
 	case event.EventQuery:
-		maskedQuery, err := w.maskOneQuery(ev.Query)
+		maskedQuery, _, err := w.maskOneQuery(ev.Query)
 		if err != nil {
 			if maskedQuery != "" { // problematic
 				ev.Query = maskedQuery
 				err = nil
 				w.Stats.Problematic += 1
 			} else {
-				ev.Query = fmt.Sprintf("/* FAILED: %v */ %s", err, ev.Query)
+				ev.Query = failedComment(err)
 			}
 			return ev, err
 		}
@@ -152,13 +157,20 @@ func (w *EventWorker) MaskOne(ev event.MySQLEvent) (event.MySQLEvent, error) {
 	case event.EventStmtPrepare:
 		newSQL, err := w.PrepareOne(ev.StmtID, ev.Query)
 		if err != nil {
+			ev.Query = failedComment(err)
 			return ev, err
 		}
 		ev.Query = newSQL
 
 	case event.EventStmtExecute:
 		maskedParams, err := w.MaskOneExecute(ev.StmtID, ev.Params)
+		if _, ok := err.(MultiError); ok { // problematic, masked under guessed types
+			ev.Params = maskedParams
+			w.Stats.Problematic += 1
+			return ev, err
+		}
 		if err != nil {
+			ev.Params = make([]interface{}, len(ev.Params))
 			return ev, err
 		}
 		ev.Params = maskedParams
@@ -173,6 +185,11 @@ func (w *EventWorker) MaskOne(ev event.MySQLEvent) (event.MySQLEvent, error) {
 	return ev, nil
 }
 
+// A comment of `err` in place of a failed query, errors never contain values in the query
+func failedComment(err error) string {
+	return fmt.Sprintf("/* FAILED: %s */", strings.ReplaceAll(err.Error(), "*/", "* /"))
+}
+
 func datumToEventParam(datum types.Datum) interface{} {
 	/*
 		case KindMysqlDecimal:
@@ -0,0 +1,157 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zyguan/mysql-replay/event"
+)
+
+func TestMaskOneExecute(t *testing.T) {
+	t.Parallel()
+
+	w := NewEventWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, nil)
+
+	cases := []struct {
+		sql    string
+		masked interface{}
+	}{
+		{"SELECT * FROM orders WHERE o_c_id = ?", "int(11) 7"},
+		{"SELECT * FROM orders WHERE o_c_id = ? + 1", "int(11) 7"},
+		{"SELECT * FROM orders WHERE o_c_id = -? * 2", "int(11) 7"},
+		{"SELECT * FROM customer WHERE c_last = CAST(? AS CHAR)", "varchar(16) 7"},
+	}
+	for i, c := range cases {
+		stmtID := uint64(i)
+		_, err := w.PrepareOne(stmtID, c.sql)
+		require.Nil(t, err)
+		params, err := w.MaskOneExecute(stmtID, []interface{}{int64(7)})
+		require.Nil(t, err)
+		require.Equal(t, []interface{}{c.masked}, params, c.sql)
+	}
+}
+
+func TestMaskOneExecuteAmbiguous(t *testing.T) {
+	t.Parallel()
+
+	w := NewEventWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, nil)
+
+	_, err := w.PrepareOne(1, "SELECT * FROM customer WHERE ? IN (c_id, c_last)")
+	require.Nil(t, err)
+	params, err := w.MaskOneExecute(1, []interface{}{int64(7)})
+	require.Equal(t, []interface{}{"varchar(16) 7"}, params)
+	require.IsType(t, MultiError{}, err)
+	require.Equal(t, []string{"type for constant 1 is ambiguous among varchar(16) of `test.customer.c_last`, int(11) of `test.customer.c_id`"}, ErrorMessages(err))
+}
+
+func TestMaskOneExecuteNotInferred(t *testing.T) {
+	t.Parallel()
+
+	w := NewEventWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, nil)
+
+	// params lost in implicit casts are masked with their own types
+	_, err := w.PrepareOne(1, "SELECT * FROM customer WHERE c_last = CONCAT(?, 'x')")
+	require.Nil(t, err)
+	params, err := w.MaskOneExecute(1, []interface{}{"SECRETVAL"})
+	require.IsType(t, MultiError{}, err)
+	require.Equal(t, []string{"type for constant 1 not inferred"}, ErrorMessages(err))
+	require.Equal(t, []interface{}{"var_string(5) SECRETVAL"}, params)
+
+	ev, err := w.MaskOne(event.MySQLEvent{
+		Type:   event.EventStmtExecute,
+		StmtID: 1,
+		Params: []interface{}{"SECRETVAL"},
+	})
+	require.IsType(t, MultiError{}, err)
+	require.Equal(t, []interface{}{"var_string(5) SECRETVAL"}, ev.Params)
+	require.Equal(t, Stats{All: 1, Problematic: 1}, w.Stats)
+}
+
+func TestMaskOneExecuteStructural(t *testing.T) {
+	t.Parallel()
+
+	w := NewEventWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, nil)
+
+	_, err := w.PrepareOne(1, "SELECT * FROM customer WHERE SUBSTRING(c_last, ?, ?) = ? AND c_since < DATE_ADD(?, INTERVAL ? DAY)")
+	require.Nil(t, err)
+	params, err := w.MaskOneExecute(1, []interface{}{int64(1), int64(2), "ab", "2020-01-01", int64(3)})
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{int64(1), int64(2), "varchar(16) ab", "datetime 2020-01-01 00:00:00", int64(3)}, params)
+}
+
+func TestMaskOneExecutePattern(t *testing.T) {
+	t.Parallel()
+
+	w := NewEventWorker(newTestDB(t), MaskFuncMap["format-preserving"], nil, false, nil)
+
+	_, err := w.PrepareOne(1, "SELECT * FROM customer WHERE c_last LIKE ? AND c_last <> ?")
+	require.Nil(t, err)
+	params, err := w.MaskOneExecute(1, []interface{}{"abc%", "abc%"})
+	require.Nil(t, err)
+	require.Equal(t, "xhq%", params[0])
+
+	// patterns are masked like values by `workload-sim`
+	w = NewEventWorker(newTestDB(t), MaskFuncMap["workload-sim"], nil, false, nil)
+	_, err = w.PrepareOne(1, "SELECT * FROM customer WHERE c_last LIKE ? AND c_last <> ?")
+	require.Nil(t, err)
+	params, err = w.MaskOneExecute(1, []interface{}{"abc%", "abc%"})
+	require.Nil(t, err)
+	require.Equal(t, params[1], params[0])
+}
+
+func TestMaskOneMultiStatements(t *testing.T) {
+	t.Parallel()
+
+	w := NewEventWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, nil)
+
+	ev, err := w.MaskOne(event.MySQLEvent{
+		Type:  event.EventQuery,
+		Query: "SET @a = 1; SELECT * FROM customer WHERE c_id = 1",
+	})
+	require.Nil(t, err)
+	require.Equal(t, "SET @`a`='bigint(20) 1'; SELECT * FROM `test`.`customer` WHERE `c_id`='int(11) 1'", ev.Query)
+	require.Equal(t, Stats{All: 1, Success: 1}, w.Stats)
+}
+
+func TestMaskOneStrict(t *testing.T) {
+	t.Parallel()
+
+	nameMap := NewGlobalNameMap(map[string]string{
+		"test.customer.c_id": "db0.table0.col0",
+	})
+	nameMap.SetStrict(true)
+	w := NewEventWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, nameMap)
+
+	// failed events never contain the original statements or params
+	ev, err := w.MaskOne(event.MySQLEvent{
+		Type:  event.EventQuery,
+		Query: "SELECT c_balance FROM customer WHERE c_id = 42",
+	})
+	require.NotNil(t, err)
+	require.Regexp(t, `^/\* FAILED: .* \*/$`, ev.Query)
+	require.NotContains(t, ev.Query, "42")
+
+	// parser errors quote the text near the error
+	ev, err = w.MaskOne(event.MySQLEvent{
+		Type:  event.EventQuery,
+		Query: "SELECT c_balance FROM customer WHERE c_last = 'secret' AND",
+	})
+	require.NotNil(t, err)
+	require.Equal(t, "/* FAILED: failed to parse statement */", ev.Query)
+
+	ev, err = w.MaskOne(event.MySQLEvent{
+		Type:   event.EventStmtPrepare,
+		StmtID: 1,
+		Query:  "SELECT c_balance FROM customer WHERE c_id = ? AND c_last = 'secret'",
+	})
+	require.NotNil(t, err)
+	require.NotContains(t, ev.Query, "secret")
+
+	ev, err = w.MaskOne(event.MySQLEvent{
+		Type:   event.EventStmtExecute,
+		StmtID: 1,
+		Params: []interface{}{int64(42)},
+	})
+	require.NotNil(t, err)
+	require.Equal(t, []interface{}{nil}, ev.Params)
+}
@@ -0,0 +1,96 @@
+package mask
+
+import (
+	"github.com/pingcap/parser/ast"
+)
+
+type argRole int
+
+const (
+	// compared with other data arguments, and masked with their types
+	argData argRole = iota
+	// independent of other arguments, like the condition of `IF`
+	argOther
+	// never masked, like JSON paths, interval values and units, format strings and positions
+	argStructural
+)
+
+// Roles of arguments of a built-in function
+type funcSignature struct {
+	// roles of leading arguments
+	args []argRole
+	// role of the remaining arguments
+	rest argRole
+	// arguments are pairs of conditions and results, with an optional `ELSE` result, like `CASE`
+	whenThen bool
+	// whether the result is one of the data arguments, like `COALESCE` or `DATE_ADD`
+	passThrough bool
+}
+
+func (s funcSignature) role(i int, n int) argRole {
+	if s.whenThen {
+		if i%2 == 0 && i != n-1 {
+			return argOther
+		}
+		return argData
+	}
+	if i < len(s.args) {
+		return s.args[i]
+	}
+	return s.rest
+}
+
+var (
+	dataThenStructural = funcSignature{args: []argRole{argData}, rest: argStructural, passThrough: true}
+	formatted          = funcSignature{args: []argRole{argOther}, rest: argStructural}
+)
+
+// Signatures of built-in functions by names in lower case, which are shared by function calls
+// in the AST and scalar functions in plans. Functions not listed here are handled as usual.
+var funcSignatures = map[string]funcSignature{
+	ast.In:       {rest: argData},
+	ast.Like:     {args: []argRole{argData, argData}, rest: argStructural},
+	ast.Regexp:   {rest: argData},
+	ast.Coalesce: {rest: argData, passThrough: true},
+	ast.Ifnull:   {rest: argData, passThrough: true},
+	ast.Nullif:   {rest: argData, passThrough: true},
+	ast.Greatest: {rest: argData, passThrough: true},
+	ast.Least:    {rest: argData, passThrough: true},
+	ast.If:       {args: []argRole{argOther}, rest: argData, passThrough: true},
+	ast.Case:     {whenThen: true, passThrough: true},
+
+	ast.Substring:      dataThenStructural,
+	ast.Substr:         dataThenStructural,
+	ast.Mid:            dataThenStructural,
+	ast.Left:           dataThenStructural,
+	ast.Right:          dataThenStructural,
+	ast.SubstringIndex: dataThenStructural,
+	ast.Round:          dataThenStructural,
+	ast.Truncate:       dataThenStructural,
+	ast.DateAdd:        dataThenStructural,
+	ast.DateSub:        dataThenStructural,
+	ast.AddDate:        dataThenStructural,
+	ast.SubDate:        dataThenStructural,
+	ast.Convert:        dataThenStructural,
+
+	ast.JSONExtract:  {args: []argRole{argOther}, rest: argStructural},
+	ast.DateFormat:   formatted,
+	ast.TimeFormat:   formatted,
+	ast.StrToDate:    formatted,
+	ast.FromUnixTime: formatted,
+}
+
+// Arguments of `fn` whose roles are `role`
+func argsOfRole(fn string, args []ast.ExprNode, role argRole) []ast.ExprNode {
+	sig, ok := funcSignatures[fn]
+	if !ok {
+		return nil
+	}
+	selected := []ast.ExprNode{}
+	for i, arg := range args {
+		if sig.role(i, len(args)) == role {
+			selected = append(selected, arg)
+		}
+	}
+	return selected
+}
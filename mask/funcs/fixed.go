@@ -0,0 +1,26 @@
+package funcs
+
+import (
+	"fmt"
+
+	"github.com/pingcap/tidb/types"
+)
+
+func NullMask(datum types.Datum, tp *types.FieldType) (types.Datum, *types.FieldType, error) {
+	return types.NewDatum(nil), tp, nil
+}
+
+// Create a mask function which replaces every constant with `value`, casted to the target type
+func NewFixedMask(value string) func(types.Datum, *types.FieldType) (types.Datum, *types.FieldType, error) {
+	return func(datum types.Datum, tp *types.FieldType) (types.Datum, *types.FieldType, error) {
+		fixed := types.NewStringDatum(value)
+		if tp == nil {
+			return fixed, stringTp, nil
+		}
+		casted, err := fixed.ConvertTo(maskStmtCtx, tp)
+		if err != nil {
+			return datum, tp, fmt.Errorf("cannot cast fixed value `%s` to type `%v`; %w", value, tp, err)
+		}
+		return casted, tp, nil
+	}
+}
@@ -0,0 +1,199 @@
+package funcs
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pingcap/tidb/types"
+	"github.com/zeebo/blake3"
+)
+
+const (
+	cjkBegin rune = 0x4E00 // CJK Unified Ideographs, all encoded in 3 bytes
+	cjkEnd   rune = 0x9FFF
+)
+
+// Mask a single rune into another one in the same class, based on the hash `h`
+func maskRune(r rune, h uint64) rune {
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return 'A' + rune(h%26)
+	case r >= 'a' && r <= 'z':
+		return 'a' + rune(h%26)
+	case r >= '0' && r <= '9':
+		return '0' + rune(h%10)
+	case r >= cjkBegin && r <= cjkEnd:
+		return cjkBegin + rune(h%uint64(cjkEnd-cjkBegin+1))
+	case unicode.IsDigit(r):
+		if zero, ok := digitZero(r); ok {
+			return zero + rune(h%10)
+		}
+		return r
+	case unicode.IsLetter(r):
+		runes := letterClassOf(r).runes()
+		return runes[h%uint64(len(runes))]
+	default:
+		// punctuation, spaces, marks and others are kept as is
+		return r
+	}
+}
+
+// Call `f` with each range of `table`
+func eachRange(table *unicode.RangeTable, f func(lo, hi, stride rune) bool) {
+	for _, rg := range table.R16 {
+		if !f(rune(rg.Lo), rune(rg.Hi), rune(rg.Stride)) {
+			return
+		}
+	}
+	for _, rg := range table.R32 {
+		if !f(rune(rg.Lo), rune(rg.Hi), rune(rg.Stride)) {
+			return
+		}
+	}
+}
+
+// Zero of the 10 consecutive decimal digits containing `r`, like `٠` for `٣`
+func digitZero(r rune) (rune, bool) {
+	var zero rune
+	found := false
+	eachRange(unicode.Digit, func(lo, hi, stride rune) bool {
+		if r < lo || r > hi || stride != 1 {
+			return true
+		}
+		zero, found = lo+(r-lo)/10*10, true
+		return false
+	})
+	return zero, found
+}
+
+// Class of a letter by its script, case and encoded size, e.g. lower case Cyrillic letters
+// in 2 bytes
+type letterClass struct {
+	script string
+	upper  bool
+	lower  bool
+	size   int
+}
+
+func letterClassOf(r rune) letterClass {
+	c := letterClass{upper: unicode.IsUpper(r), lower: unicode.IsLower(r), size: utf8.RuneLen(r)}
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			c.script = name
+			break
+		}
+	}
+	return c
+}
+
+// cached runes of letter classes
+var letterClassRunes sync.Map
+
+// All letters in the class in order, which always include the letter the class is of
+func (c letterClass) runes() []rune {
+	if runes, ok := letterClassRunes.Load(c); ok {
+		return runes.([]rune)
+	}
+
+	table := unicode.Letter
+	if c.script != "" {
+		table = unicode.Scripts[c.script]
+	}
+	runes := []rune{}
+	eachRange(table, func(lo, hi, stride rune) bool {
+		for r := lo; r <= hi; r += stride {
+			if unicode.IsLetter(r) && unicode.IsUpper(r) == c.upper && unicode.IsLower(r) == c.lower && utf8.RuneLen(r) == c.size {
+				runes = append(runes, r)
+			}
+		}
+		return true
+	})
+	letterClassRunes.Store(c, runes)
+	return runes
+}
+
+// Mask `s` character by character, keeping the class of each character (upper, lower,
+// digit, punctuation, CJK, or letters of other scripts by case) and thus both the rune and byte
+// length.
+//
+// Each masked character only depends on the original characters up to it, so masked
+// strings with a common prefix share the same masked prefix, e.g. `abc%` for `LIKE`
+// still matches the masked `abcdef`.
+func maskStringPreserving(s string) string {
+	m := newPreservingMasker()
+	sb := strings.Builder{}
+	sb.Grow(len(s))
+
+	for len(s) > 0 {
+		_, size := utf8.DecodeRuneInString(s)
+		_, _ = sb.WriteString(m.next(s[:size]))
+		s = s[size:]
+	}
+	return sb.String()
+}
+
+// Masks characters of a string one by one, each based on the hash of all characters so far
+type preservingMasker struct {
+	hasher *blake3.Hasher
+	sum    []byte
+}
+
+func newPreservingMasker() *preservingMasker {
+	return &preservingMasker{
+		hasher: newHasher(),
+		sum:    make([]byte, 8),
+	}
+}
+
+// Mask the next character `char`, which is a single encoded rune or an invalid byte
+func (m *preservingMasker) next(char string) string {
+	_, _ = m.hasher.WriteString(char)
+
+	r, size := utf8.DecodeRuneInString(char)
+	if r == utf8.RuneError && size == 1 {
+		// keep invalid bytes, for binary strings
+		return char
+	}
+	_, err := m.hasher.Clone().Digest().Read(m.sum)
+	if err != nil {
+		panic(err)
+	}
+	return string(maskRune(r, binary.LittleEndian.Uint64(m.sum)))
+}
+
+// Like `WorkloadSimMask`, but strings are masked in a format-preserving way
+func FormatPreservingMask(datum types.Datum, tp *types.FieldType) (types.Datum, *types.FieldType, error) {
+	switch datum.Kind() {
+	case types.KindString:
+		s := maskStringPreserving(datum.GetString())
+		datum.SetString(s, datum.Collation())
+		return datum, tp, nil
+
+	case types.KindBytes:
+		s := maskStringPreserving(string(datum.GetBytes()))
+		datum.SetBytes([]byte(s))
+		return datum, tp, nil
+
+	case types.KindMysqlEnum:
+		e := datum.GetMysqlEnum()
+		e.Name = maskStringPreserving(e.Name)
+		datum.SetMysqlEnum(e, datum.Collation())
+		return datum, tp, nil
+
+	case types.KindMysqlSet:
+		s := datum.GetMysqlSet()
+		var items []string
+		for _, e := range strings.Split(s.Name, ",") {
+			items = append(items, maskStringPreserving(e))
+		}
+		s.Name = strings.Join(items, ",")
+		datum.SetMysqlSet(s, datum.Collation())
+		return datum, tp, nil
+
+	default:
+		return WorkloadSimMask(datum, tp)
+	}
+}
@@ -0,0 +1,90 @@
+package funcs
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pingcap/tidb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatPreservingMask(t *testing.T) {
+	t.Parallel()
+
+	classOf := func(r rune) string {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return "upper"
+		case r >= 'a' && r <= 'z':
+			return "lower"
+		case r >= '0' && r <= '9':
+			return "digit"
+		case r >= cjkBegin && r <= cjkEnd:
+			return "cjk"
+		case unicode.IsDigit(r):
+			return "digit"
+		case unicode.IsLetter(r):
+			for name, table := range unicode.Scripts {
+				if unicode.Is(table, r) {
+					return fmt.Sprintf("%s %v %v", name, unicode.IsUpper(r), unicode.IsLower(r))
+				}
+			}
+			return "letter"
+		default:
+			return string(r)
+		}
+	}
+
+	tests := []string{
+		"Alice-42@x.com",
+		"+86 138-0000-0000",
+		"你好，世界",
+		"",
+		"\xff\x01abc",
+		"Zoë Ångström",
+		"Привет, Мир",
+		"Γειά σου",
+		"안녕하세요",
+		"こんにちは カタカナ",
+		"١٢٣ ٤٥٦",
+	}
+
+	for _, from := range tests {
+		to, _, err := FormatPreservingMask(types.NewStringDatum(from), nil)
+		require.Nil(t, err)
+		masked := to.GetString()
+
+		require.Equal(t, len(from), len(masked))
+		require.Equal(t, utf8.RuneCountInString(from), utf8.RuneCountInString(masked))
+		fromRunes, maskedRunes := []rune(from), []rune(masked)
+		for i := range fromRunes {
+			require.Equal(t, classOf(fromRunes[i]), classOf(maskedRunes[i]))
+			require.Equal(t, utf8.RuneLen(fromRunes[i]), utf8.RuneLen(maskedRunes[i]))
+		}
+		if from != "" {
+			require.NotEqual(t, from, masked)
+		}
+
+		again, _, err := FormatPreservingMask(types.NewStringDatum(from), nil)
+		require.Nil(t, err)
+		require.Equal(t, masked, again.GetString())
+	}
+
+	// letters out of ASCII and CJK are masked as well
+	for _, from := range []string{"ë", "Å", "Привет", "Γειά", "안녕하세요", "こんにちは", "١٢٣"} {
+		require.NotEqual(t, from, maskStringPreserving(from))
+	}
+
+	// common prefixes are masked into common prefixes
+	full := maskStringPreserving("abcdef")
+	prefix := maskStringPreserving("abc")
+	require.True(t, strings.HasPrefix(full, prefix))
+
+	// non-string datums fallback to `WorkloadSimMask`
+	to, _, err := FormatPreservingMask(types.NewIntDatum(42), nil)
+	require.Nil(t, err)
+	require.Equal(t, types.NewIntDatum(-113).String(), to.String())
+}
@@ -0,0 +1,182 @@
+package funcs
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Syntax of a pattern string
+type PatternSyntax int
+
+const (
+	// patterns of `LIKE`, with wildcards `%` and `_` and an escape character
+	PatternLike PatternSyntax = iota
+	// patterns of `REGEXP` and `RLIKE`
+	PatternRegexp
+)
+
+// Mask literal segments of `pattern` with `maskStringPreserving`, while wildcards, escapes,
+// anchors, classes and quantifiers are kept as is. `escape` is the escape character of `LIKE`.
+//
+// Each segment is masked as a string on its own, so the leading segment of `abc%` is masked
+// into the prefix of the masked `abcdef`, and the masked pattern still matches it. Only the
+// leading segment is consistent with masked values: a character is masked based on all
+// characters before it, which are unknown to later segments like `def` of `%def`.
+func MaskPattern(pattern string, syntax PatternSyntax, escape rune) string {
+	p := patternMasker{
+		chars:  splitChars(pattern),
+		masker: newPreservingMasker(),
+	}
+	p.sb.Grow(len(pattern))
+
+	if syntax == PatternRegexp {
+		p.maskRegexp()
+	} else {
+		p.maskLike(string(escape))
+	}
+	return p.sb.String()
+}
+
+type patternMasker struct {
+	chars  []string
+	pos    int
+	masker *preservingMasker
+	sb     strings.Builder
+}
+
+// Split `s` into encoded runes, with each invalid byte as a single character
+func splitChars(s string) []string {
+	chars := make([]string, 0, len(s))
+	for len(s) > 0 {
+		_, size := utf8.DecodeRuneInString(s)
+		chars = append(chars, s[:size])
+		s = s[size:]
+	}
+	return chars
+}
+
+// Whether there're characters left
+func (p *patternMasker) more() bool {
+	return p.pos < len(p.chars)
+}
+
+// Consume the next character
+func (p *patternMasker) take() string {
+	char := p.chars[p.pos]
+	p.pos += 1
+	return char
+}
+
+// Keep the next character as is, which ends the current literal segment
+func (p *patternMasker) keep() {
+	_, _ = p.sb.WriteString(p.take())
+	p.masker = newPreservingMasker()
+}
+
+// Mask the next character as a part of the current literal segment
+func (p *patternMasker) literal() {
+	_, _ = p.sb.WriteString(p.masker.next(p.take()))
+}
+
+func (p *patternMasker) maskLike(escape string) {
+	for p.more() {
+		switch p.chars[p.pos] {
+		case escape:
+			_, _ = p.sb.WriteString(p.take())
+			if p.more() {
+				p.literal()
+			}
+		case "%", "_":
+			p.keep()
+		default:
+			p.literal()
+		}
+	}
+}
+
+func (p *patternMasker) maskRegexp() {
+	for p.more() {
+		switch p.chars[p.pos] {
+		case `\`:
+			_, _ = p.sb.WriteString(p.take())
+			if !p.more() {
+				break
+			}
+			// escaped punctuations are literals, while escaped letters and digits are classes,
+			// anchors or back references like `\d`, `\b` and `\1`
+			if r, _ := utf8.DecodeRuneInString(p.chars[p.pos]); unicode.IsLetter(r) || unicode.IsDigit(r) {
+				p.keep()
+			} else {
+				p.literal()
+			}
+		case "[":
+			p.keepBracket()
+		case "{":
+			p.keepUntil("}")
+		case "(":
+			p.keep()
+			if p.more() && p.chars[p.pos] == "?" {
+				// flags and kinds of groups like `(?i)` and `(?:`
+				p.keepUntil(":", ")")
+			}
+		case ".", "*", "+", "?", "^", "$", "|", ")":
+			p.keep()
+		default:
+			p.literal()
+		}
+	}
+}
+
+// Keep characters up to and including any of `ends`
+func (p *patternMasker) keepUntil(ends ...string) {
+	for p.more() {
+		char := p.chars[p.pos]
+		p.keep()
+		for _, end := range ends {
+			if char == end {
+				return
+			}
+		}
+	}
+}
+
+// Keep a bracket expression like `[^a-z]` or `[[:digit:]]` as is
+func (p *patternMasker) keepBracket() {
+	p.keep() // [
+	if p.more() && p.chars[p.pos] == "^" {
+		p.keep()
+	}
+	if p.more() && p.chars[p.pos] == "]" {
+		p.keep()
+	}
+	for p.more() {
+		switch char := p.chars[p.pos]; char {
+		case "]":
+			p.keep()
+			return
+		case `\`:
+			p.keep()
+			if p.more() {
+				p.keep()
+			}
+		case "[":
+			p.keep()
+			if p.more() {
+				switch kind := p.chars[p.pos]; kind {
+				case ":", "=", ".":
+					// character classes like `[:digit:]`, ended by `:]`
+					p.keep()
+					for p.more() && !(p.chars[p.pos-1] == kind && p.chars[p.pos] == "]") {
+						p.keep()
+					}
+					if p.more() {
+						p.keep()
+					}
+				}
+			}
+		default:
+			p.keep()
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package funcs
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskPattern(t *testing.T) {
+	t.Parallel()
+
+	// the leading segment is masked like the prefix of a matching value
+	value := maskStringPreserving("abcdef")
+	masked := MaskPattern("abc%", PatternLike, '\\')
+	require.True(t, strings.HasSuffix(masked, "%"))
+	require.True(t, strings.HasPrefix(value, strings.TrimSuffix(masked, "%")))
+
+	masked = MaskPattern("^abc.*", PatternRegexp, 0)
+	require.Regexp(t, regexp.MustCompile(masked), value)
+
+	// later segments are masked on their own, so they don't match the masked value
+	masked = MaskPattern("%def", PatternLike, '\\')
+	require.Equal(t, "%"+maskStringPreserving("def"), masked)
+	require.False(t, strings.HasSuffix(value, strings.TrimPrefix(masked, "%")))
+
+	// wildcards, escapes and anchors are kept, literal segments are masked
+	tests := []struct {
+		pattern string
+		syntax  PatternSyntax
+		escape  rune
+		shape   string
+	}{
+		{"abc%def_", PatternLike, '\\', `^[a-z]{3}%[a-z]{3}_$`},
+		{`%10\%_off`, PatternLike, '\\', `^%[0-9]{2}\\%_[a-z]{3}$`},
+		{"a|b%x", PatternLike, '|', `^[a-z]\|[a-z]%[a-z]$`},
+		{`^abc\.[0-9]+$`, PatternRegexp, 0, `^\^[a-z]{3}\\\.\[0-9\]\+\$$`},
+		{`(?i)foo\d{2,3}(bar|baz)`, PatternRegexp, 0, `^\(\?i\)[a-z]{3}\\d\{2,3\}\([a-z]{3}\|[a-z]{3}\)$`},
+		{`x[[:digit:]]y`, PatternRegexp, 0, `^[a-z]\[\[:digit:\]\][a-z]$`},
+	}
+	for _, c := range tests {
+		masked := MaskPattern(c.pattern, c.syntax, c.escape)
+		require.Regexp(t, regexp.MustCompile(c.shape), masked, c.pattern)
+		require.NotEqual(t, c.pattern, masked, c.pattern)
+		require.Equal(t, masked, MaskPattern(c.pattern, c.syntax, c.escape), c.pattern)
+	}
+}
@@ -0,0 +1,76 @@
+package funcs
+
+import (
+	"math"
+
+	"github.com/pingcap/tidb/types"
+)
+
+// Mask `from` into a number with the same count of decimal digits, which is no more than `max`
+func maskUint64InRange(from uint64, max uint64) uint64 {
+	if from == 0 {
+		return 0
+	}
+
+	low := uint64(1)
+	for low <= from/10 {
+		low *= 10
+	}
+	high := max
+	if low <= (math.MaxUint64-9)/10 && low*10-1 < max {
+		high = low*10 - 1
+	}
+
+	return low + hashUint64(from)%(high-low+1)
+}
+
+func maskInt64InRange(from int64) int64 {
+	if from >= 0 {
+		return int64(maskUint64InRange(uint64(from), math.MaxInt64))
+	}
+	abs := uint64(-(from + 1)) + 1
+	return -int64(maskUint64InRange(abs, math.MaxInt64))
+}
+
+// Keep the year and the month of a time, mask the rest
+func maskTimeInRange(t types.Time) (types.Time, error) {
+	if t.IsZero() || t.Month() == 0 {
+		return t, nil
+	}
+
+	masked, err := maskTime(t)
+	if err != nil {
+		return t, err
+	}
+
+	year, month := t.Year(), t.Month()
+	day := (masked.Day()-1)%lastDayOfMonth(year, month) + 1
+	coreTime := types.FromDate(year, month, day, masked.Hour(), masked.Minute(), masked.Second(), masked.Microsecond())
+	return types.NewTime(coreTime, t.Type(), t.Fsp()), nil
+}
+
+// Mask numbers into the same sign and count of digits, and times into the same month.
+// Strings are masked like `FormatPreservingMask`.
+func RangePreservingMask(datum types.Datum, tp *types.FieldType) (types.Datum, *types.FieldType, error) {
+	switch datum.Kind() {
+	case types.KindInt64:
+		datum.SetInt64(maskInt64InRange(datum.GetInt64()))
+		return datum, tp, nil
+
+	case types.KindUint64:
+		datum.SetUint64(maskUint64InRange(datum.GetUint64(), math.MaxUint64))
+		return datum, tp, nil
+
+	case types.KindMysqlTime:
+		t, err := maskTimeInRange(datum.GetMysqlTime())
+		if err != nil {
+			return datum, tp, err
+		}
+		datum.SetMysqlTime(t)
+		return datum, tp, nil
+
+	default:
+		// floats and decimals from `WorkloadSimMask` have already kept the digits
+		return FormatPreservingMask(datum, tp)
+	}
+}
@@ -0,0 +1,32 @@
+package funcs
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangePreservingMask(t *testing.T) {
+	t.Parallel()
+
+	for _, from := range []int64{0, 7, 42, -42, 4200, -9223372036854775808, 9223372036854775807} {
+		to, _, err := RangePreservingMask(types.NewIntDatum(from), nil)
+		require.Nil(t, err)
+		masked := to.GetInt64()
+		require.Equal(t, len(strconv.FormatInt(from, 10)), len(strconv.FormatInt(masked, 10)), from)
+	}
+
+	time, err := types.ParseTime(maskStmtCtx, "2021-10-19 12:34:56", mysql.TypeDatetime, 0)
+	require.Nil(t, err)
+	to, _, err := RangePreservingMask(types.NewTimeDatum(time), nil)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(to.GetMysqlTime().String(), "2021-10-"))
+
+	fixed, _, err := NewFixedMask("2020-01-01")(types.NewStringDatum("x"), types.NewFieldType(mysql.TypeDate))
+	require.Nil(t, err)
+	require.Equal(t, "2020-01-01", fixed.GetMysqlTime().String())
+}
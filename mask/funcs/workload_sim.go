@@ -36,8 +36,29 @@ const (
 
 var maskStmtCtx = mock.NewContext().GetSessionVars().StmtCtx
 
+// Key derived from the user secret, nil if no secret is given
+var secretKey []byte
+
+// Set the secret for all hashes in mask functions, so that masked values can't be
+// reversed without it, while staying deterministic for the same secret.
+// An empty secret resets to the unkeyed default context.
+func SetSecret(secret []byte) {
+	if len(secret) == 0 {
+		secretKey = nil
+		return
+	}
+	secretKey = make([]byte, 32)
+	blake3.DeriveKey(defaultContext, secret, secretKey)
+}
+
 func newHasher() *blake3.Hasher {
-	hasher := blake3.NewDeriveKey(defaultContext)
+	if secretKey == nil {
+		return blake3.NewDeriveKey(defaultContext)
+	}
+	hasher, err := blake3.NewKeyed(secretKey)
+	if err != nil {
+		panic(err)
+	}
 	return hasher
 }
 
@@ -75,3 +75,35 @@ func TestWorkloadSimMask(t *testing.T) {
 		require.Equal(t, test.expected.String(), to.String()) // fixme: cannot expect the bit representation to be exactly same now
 	}
 }
+
+func TestWorkloadSimMaskWithSecret(t *testing.T) {
+	defer SetSecret(nil)
+
+	mustMask := func(datum types.Datum) string {
+		to, _, err := WorkloadSimMask(datum, nil)
+		require.Nil(t, err)
+		return to.String()
+	}
+	from := []types.Datum{
+		types.NewIntDatum(42),
+		types.NewUintDatum(4200),
+		types.NewStringDatum("male"),
+	}
+
+	unkeyed := []string{}
+	for _, d := range from {
+		unkeyed = append(unkeyed, mustMask(d))
+	}
+
+	SetSecret([]byte("s3cr3t"))
+	for i, d := range from {
+		keyed := mustMask(d)
+		require.NotEqual(t, unkeyed[i], keyed)
+		require.Equal(t, keyed, mustMask(d)) // deterministic for the same secret
+	}
+
+	SetSecret(nil)
+	for i, d := range from {
+		require.Equal(t, unkeyed[i], mustMask(d))
+	}
+}
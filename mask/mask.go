@@ -2,8 +2,12 @@ package mask
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/BugenZhao/sql-masker/mask/funcs"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/expression"
 	"github.com/pingcap/tidb/sessionctx/stmtctx"
 	"github.com/pingcap/tidb/types"
 )
@@ -12,27 +16,48 @@ type MaskFunc struct {
 	Description string
 	// Mask `datum` with type `tp` into new datum and type
 	fn func(datum types.Datum, tp *types.FieldType) (types.Datum, *types.FieldType, error)
+	// Mask literal segments of a pattern string, nil if patterns are masked as other values
+	pattern func(pattern string, syntax funcs.PatternSyntax, escape rune) string
 }
 
 // All mask functions
 var MaskFuncMap = map[string]MaskFunc{
-	"workload-sim": {"For workload simulation project", funcs.WorkloadSimMask},
-	"debug":        {"Replace every constant with its inferred type, for debug usage", funcs.DebugMask},
-	"debug-color":  {"Like `debug`, but in ANSI color", funcs.DebugMaskColor},
-	"identical":    {"Dry-run baseline", funcs.IdenticalMask},
+	"workload-sim":      {"For workload simulation project", funcs.WorkloadSimMask, nil},
+	"format-preserving": {"Like `workload-sim`, but keeps the class of each character and the length of strings", funcs.FormatPreservingMask, funcs.MaskPattern},
+	"range-preserving":  {"Keep the sign and digits of numbers and the month of times, strings are like `format-preserving`", funcs.RangePreservingMask, funcs.MaskPattern},
+	"debug":             {"Replace every constant with its inferred type, for debug usage", funcs.DebugMask, nil},
+	"debug-color":       {"Like `debug`, but in ANSI color", funcs.DebugMaskColor, nil},
+	"identical":         {"Dry-run baseline", funcs.IdenticalMask, nil},
+}
+
+// Create a `StatementContext` for casting constants before masking
+func NewMaskStmtContext() *stmtctx.StatementContext {
+	sc := &stmtctx.StatementContext{
+		TimeZone: time.UTC,
+	}
+	sc.IgnoreTruncate = true
+	return sc
 }
 
 // Convert `datum` to `toType` and then mask using `maskFunc`,
-// returns new datum and its coresponding type
+// returns new datum and its coresponding type.
+//
+// Casted values are normalized as how they're stored in columns of `toType`, e.g., trailing
+// spaces of `CHAR` are removed, so that it's consistent for the same value in different forms.
+// Errors never contain `datum`, since they may be written into masked output.
 func ConvertAndMask(sc *stmtctx.StatementContext, datum types.Datum, toType *types.FieldType, maskFunc MaskFunc) (types.Datum, *types.FieldType, error) {
 	castedDatum, err := datum.ConvertTo(sc, toType)
 	if err != nil {
-		return datum, nil, fmt.Errorf("cannot cast `%v` to type `%v`; %w", datum, toType, err)
+		// errors of casting quote the value
+		return datum, nil, fmt.Errorf("cannot cast %s value to type `%v`", types.KindStr(datum.Kind()), toType)
+	}
+	if toType.Tp == mysql.TypeString && castedDatum.Kind() == types.KindString {
+		castedDatum.SetString(strings.TrimRight(castedDatum.GetString(), " "), castedDatum.Collation())
 	}
 
 	maskedDatum, maskedType, err := maskFunc.fn(*castedDatum.Clone(), toType)
 	if err != nil {
-		return castedDatum, toType, fmt.Errorf("failed to mask `%v`; %w", castedDatum, err)
+		return castedDatum, toType, fmt.Errorf("failed to mask %s value of type `%v`; %w", types.KindStr(castedDatum.Kind()), toType, err)
 	}
 
 	if maskedType == nil {
@@ -40,3 +65,85 @@ func ConvertAndMask(sc *stmtctx.StatementContext, datum types.Datum, toType *typ
 	}
 	return maskedDatum, maskedType, nil
 }
+
+// Masks constants of inferred types with the mask function chosen by `policy`. All workers
+// mask values through it, so that a value of a column is always masked into the same one,
+// no matter whether it's from SQL text, events or data files.
+type valueMasker struct {
+	maskFunc    MaskFunc
+	policy      *MaskPolicy
+	ignoreIntPK bool
+	stmtContext *stmtctx.StatementContext
+}
+
+func newValueMasker(maskFunc MaskFunc, policy *MaskPolicy, ignoreIntPK bool) valueMasker {
+	return valueMasker{
+		maskFunc:    maskFunc,
+		policy:      policy,
+		ignoreIntPK: ignoreIntPK,
+		stmtContext: NewMaskStmtContext(),
+	}
+}
+
+// The type of a constant `datum` on its own, like the type of a param
+func ownInferredType(datum types.Datum) *InferredType {
+	ft := types.NewFieldType(mysql.TypeUnspecified)
+	types.DefaultParamTypeForValue(datum.GetValue(), ft)
+	return NewInferredType(ft)
+}
+
+// Mask a non-null `datum` of type `tp`, returns false if it should be kept as is
+func (m *valueMasker) mask(datum types.Datum, tp *InferredType) (types.Datum, *types.FieldType, bool, error) {
+	if tp.IsPrimaryKey() && m.ignoreIntPK {
+		return datum, nil, false, nil
+	}
+
+	// values compared with JSON like `JSON_EXTRACT(doc, '$.a') = 'abc'` are JSON scalars rather
+	// than JSON texts, so they are masked with their own types
+	if tp.Column == nil && tp.Ft.Tp == mysql.TypeJSON {
+		tp = ownInferredType(datum)
+	}
+
+	maskFunc := m.policy.Resolve(tp, m.maskFunc)
+	maskedDatum, maskedType, err := ConvertAndMask(m.stmtContext, datum, tp.Ft, maskFunc)
+	if err != nil {
+		return datum, nil, false, err
+	}
+	return maskedDatum, maskedType, true, nil
+}
+
+// Mask a non-null pattern `datum` of type `tp` like `mask`, but only literal segments of it are
+// masked, so that wildcards, escapes and anchors are kept.
+//
+// Patterns are masked in a format-preserving way, so that a pattern like `abc%` still matches
+// values masked by `format-preserving` and `range-preserving`. Hashed strings of `workload-sim`
+// can't be matched by any pattern, so its patterns are masked like the values they're compared to.
+func (m *valueMasker) maskPattern(datum types.Datum, tp *InferredType, pattern Pattern) (types.Datum, *types.FieldType, bool, error) {
+	if tp.IsPrimaryKey() && m.ignoreIntPK {
+		return datum, nil, false, nil
+	}
+
+	maskFunc := m.policy.Resolve(tp, m.maskFunc)
+	switch datum.Kind() {
+	case types.KindString, types.KindBytes:
+		if maskFunc.pattern == nil {
+			break
+		}
+		// patterns are not casted to the column type, which may truncate them
+		masked := maskFunc.pattern(datum.GetString(), pattern.Syntax, pattern.Escape)
+		if datum.Kind() == types.KindBytes {
+			datum.SetBytes([]byte(masked))
+		} else {
+			datum.SetString(masked, datum.Collation())
+		}
+		return datum, ownInferredType(datum).Ft, true, nil
+	}
+	return m.mask(datum, tp)
+}
+
+// Mask a non-null `datum` of column `col`, like `mask`
+func (m *valueMasker) maskForColumn(datum types.Datum, col *expression.Column) (types.Datum, *types.FieldType, bool, error) {
+	tp := NewColumnInferredType(col)
+	tp.SourceColumns = []*expression.Column{col}
+	return m.mask(datum, tp)
+}
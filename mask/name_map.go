@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/BugenZhao/sql-masker/dict"
+	"github.com/BugenZhao/sql-masker/mask/funcs"
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/tidb/expression"
@@ -15,7 +16,28 @@ const (
 	defaultPrefix      = "_h"
 )
 
+// Secret for keyed hashing of names, nil if not given
+var nameSecret []byte
+
+// Set the secret for both mask functions and name dictionaries,
+// an empty secret resets to the unkeyed default
+func SetSecret(secret []byte) {
+	funcs.SetSecret(secret)
+	if len(secret) == 0 {
+		nameSecret = nil
+	} else {
+		nameSecret = secret
+	}
+}
+
 func NewDefaultDictionary() *dict.Dictionary {
+	if nameSecret != nil {
+		d, err := dict.NewKeyedDictionary(defaultHashContext, nameSecret, defaultPrefix)
+		if err != nil {
+			panic(err)
+		}
+		return d
+	}
 	return dict.NewDictionary(defaultHashContext, defaultPrefix)
 }
 
@@ -31,9 +53,14 @@ func NewGlobalNameMap(columns map[string]string) *NameMap {
 	}
 
 	return &NameMap{
-		DBs:     dbs,
-		Tables:  tables,
-		Columns: columns,
+		DBs:         dbs,
+		Tables:      tables,
+		Columns:     columns,
+		Indexes:     map[string]string{},
+		Constraints: map[string]string{},
+		Partitions:  map[string]string{},
+		Variables:   map[string]string{},
+		Routines:    map[string]string{},
 	}
 }
 
@@ -43,36 +70,118 @@ func NewLocalNameMap(global *NameMap, columnsSubSet []*expression.Column, curren
 	}
 
 	columns := make(map[string]string)
+	missing := make(map[string]string)
 
 	// preprocess to split mapping of `db.table.col` into pieces like `table.col` or `col`,
 	// based on provided `columnsSubSet`
 	for _, col := range columnsSubSet {
 		origName := col.OrigName
 		mappedName := global.column(origName)
+		_, _, err := nameMapFind(origName, global.Columns)
 		origTokens := strings.Split(origName, ".")
 		mappedTokens := strings.Split(mappedName, ".")
 		for i := 0; i < len(origTokens); i++ {
 			origSuffix := strings.Join(origTokens[i:], ".")
 			mappedSuffix := strings.Join(mappedTokens[i:], ".")
 			columns[origSuffix] = mappedSuffix
+			if err != nil {
+				missing[origSuffix] = strings.ToLower(origName)
+			}
 		}
 	}
 
 	return &NameMap{
-		Tables:    global.Tables,
-		Columns:   columns,
-		currentDB: currentDB,
-		dict:      NewDefaultDictionary(),
+		DBs:         global.DBs,
+		Tables:      global.Tables,
+		Columns:     columns,
+		Indexes:     global.Indexes,
+		Constraints: global.Constraints,
+		Partitions:  global.Partitions,
+		Variables:   global.Variables,
+		Routines:    global.Routines,
+		missing:     missing,
+		currentDB:   currentDB,
+		dict:        NewDefaultDictionary(),
 	}, nil
 }
 
 type NameMap struct {
-	DBs     map[string]string `json:"dbs"`
+	DBs map[string]string `json:"dbs"`
+	// tables, views and sequences, which share the same namespace
 	Tables  map[string]string `json:"tables"`
 	Columns map[string]string `json:"columns"`
+	// names of objects on tables like `db.table.name`, where constraints are foreign keys and
+	// checks, while other constraints are indexes
+	Indexes     map[string]string `json:"indexes,omitempty"`
+	Constraints map[string]string `json:"constraints,omitempty"`
+	Partitions  map[string]string `json:"partitions,omitempty"`
+	// user variables without `@`
+	Variables map[string]string `json:"variables,omitempty"`
+	// stored procedures, triggers and events like `db.name`
+	Routines map[string]string `json:"routines,omitempty"`
 
 	dict      *dict.Dictionary
 	currentDB string
+	strict    bool
+	// names declared in the statement like aliases, which are never in the map
+	aliases map[string]bool
+	// identifiers not found in the map like `column t.unknown`, recorded by local maps only
+	unmapped []string
+	// columns in `Columns` which are kept as is since they're not in the global map
+	missing map[string]string
+}
+
+// Set whether statements with identifiers not found in the map should fail
+func (m *NameMap) SetStrict(strict bool) {
+	m.strict = strict
+}
+
+// Record `name` of `kind` not found in the map, unless it's declared in the statement. Only
+// local maps record, since the global one is shared among workers.
+func (m *NameMap) miss(kind string, name string) {
+	if m.dict == nil {
+		return
+	}
+	name = strings.ToLower(name)
+	tokens := strings.Split(name, ".")
+	if m.aliases[tokens[len(tokens)-1]] {
+		return
+	}
+	m.unmapped = append(m.unmapped, fmt.Sprintf("%s %s", kind, name))
+}
+
+// Collects names declared in a statement like aliases of tables and fields and names of CTEs
+type aliasCollector struct {
+	aliases map[string]bool
+}
+
+func (v *aliasCollector) Enter(in ast.Node) (ast.Node, bool) {
+	switch in := in.(type) {
+	case *ast.TableSource:
+		v.aliases[in.AsName.L] = true
+	case *ast.SelectField:
+		v.aliases[in.AsName.L] = true
+	case *ast.WithClause:
+		for _, cte := range in.CTEs {
+			v.aliases[cte.Name.L] = true
+			for _, col := range cte.ColNameList {
+				v.aliases[col.L] = true
+			}
+		}
+	}
+	return in, false
+}
+
+func (v *aliasCollector) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+// Collect names declared in `node`, which may be referred to before declared
+func (m *NameMap) declareAliases(node ast.Node) {
+	v := &aliasCollector{aliases: map[string]bool{}}
+	node.Accept(v)
+	delete(v.aliases, "")
+	m.aliases = v.aliases
 }
 
 func nameMapFind(from string, m map[string]string) (prefix []string, mappedSuffix string, _ error) {
@@ -111,7 +220,16 @@ func (m *NameMap) mapPrefix(from []string) []string {
 }
 
 func (m *NameMap) column(from string) string {
-	prefix, mappedSuffix, _ := nameMapFind(from, m.Columns)
+	prefix, mappedSuffix, err := nameMapFind(from, m.Columns)
+	if err != nil {
+		m.miss("column", from)
+	} else {
+		// found but kept as is
+		suffix := strings.Split(strings.ToLower(from), ".")[len(prefix):]
+		if origName, ok := m.missing[strings.Join(suffix, ".")]; ok {
+			m.miss("column", origName)
+		}
+	}
 	prefix = m.mapPrefix(prefix)
 	to := joinMapped(prefix, mappedSuffix)
 	return to
@@ -135,12 +253,18 @@ func (m *NameMap) ColumnName(name *ast.ColumnName) *ast.ColumnName {
 func (m *NameMap) table(from string) string {
 	if m.currentDB != "" && !strings.Contains(from, ".") {
 		from = fmt.Sprintf("%s.%s", m.currentDB, from)
-		prefix, mappedSuffix, _ := nameMapFind(from, m.Tables)
+		prefix, mappedSuffix, err := nameMapFind(from, m.Tables)
+		if err != nil {
+			m.miss("table", from)
+		}
 		prefix = m.mapPrefix(prefix)
 		to := joinMapped(prefix, mappedSuffix)
 		return strings.Split(to, ".")[1]
 	} else {
-		prefix, mappedSuffix, _ := nameMapFind(from, m.Tables)
+		prefix, mappedSuffix, err := nameMapFind(from, m.Tables)
+		if err != nil {
+			m.miss("table", from)
+		}
 		prefix = m.mapPrefix(prefix)
 		to := joinMapped(prefix, mappedSuffix)
 		return to
@@ -154,6 +278,15 @@ func (m *NameMap) TableName(name *ast.TableName) *ast.TableName {
 	} else {
 		from = fmt.Sprintf("%v.%v", name.Schema, name.Name)
 	}
+	for _, hint := range name.IndexHints {
+		for i := range hint.IndexNames {
+			hint.IndexNames[i] = model.NewCIStr(m.Index(from, hint.IndexNames[i].O))
+		}
+	}
+	for i := range name.PartitionNames {
+		name.PartitionNames[i] = model.NewCIStr(m.Partition(from, name.PartitionNames[i].O))
+	}
+
 	mapped := m.table(from)
 	tokens := strings.Split(mapped, ".")
 	if len(tokens) >= 1 {
@@ -165,9 +298,309 @@ func (m *NameMap) TableName(name *ast.TableName) *ast.TableName {
 	return name
 }
 
+// Map a full table name like `db.table`, returns it as is if not found
+func (m *NameMap) FullTableName(from string) string {
+	return m.table(from)
+}
+
+// Map a full column name like `db.table.column`, returns it as is if not found
+func (m *NameMap) FullColumnName(from string) string {
+	return m.column(from)
+}
+
 func (m *NameMap) DB(from string) string {
 	if to, ok := m.DBs[from]; ok {
 		return to
 	}
+	if from != "" {
+		m.miss("database", from)
+	}
 	return from
 }
+
+// Map the name of an object of `kind` on `table` like `db.table` or `table`, names not found are
+// mapped by the dictionary if any
+func (m *NameMap) tableObject(objects map[string]string, kind string, table string, name string) string {
+	if name == "" || strings.EqualFold(name, "primary") {
+		return name
+	}
+	if m.currentDB != "" && !strings.Contains(table, ".") {
+		table = fmt.Sprintf("%s.%s", m.currentDB, table)
+	}
+	if to, ok := objects[strings.ToLower(fmt.Sprintf("%s.%s", table, name))]; ok {
+		tokens := strings.Split(to, ".")
+		return tokens[len(tokens)-1]
+	}
+	m.miss(kind, fmt.Sprintf("%s.%s", table, name))
+	if m.dict != nil {
+		return m.dict.Map(strings.ToLower(name))
+	}
+	return name
+}
+
+// Map the name of an index on `table` like `db.table` or `table`
+func (m *NameMap) Index(table string, name string) string {
+	return m.tableObject(m.Indexes, "index", table, name)
+}
+
+// Map the name of a foreign key or check constraint on `table` like `db.table` or `table`
+func (m *NameMap) Constraint(table string, name string) string {
+	return m.tableObject(m.Constraints, "constraint", table, name)
+}
+
+// Map the name of a partition of `table` like `db.table` or `table`
+func (m *NameMap) Partition(table string, name string) string {
+	return m.tableObject(m.Partitions, "partition", table, name)
+}
+
+// Map the name of a user variable without `@`, names not found are mapped by the dictionary if any
+func (m *NameMap) Variable(name string) string {
+	if to, ok := m.Variables[strings.ToLower(name)]; ok {
+		return to
+	}
+	m.miss("variable", name)
+	if m.dict != nil {
+		return m.dict.Map(strings.ToLower(name))
+	}
+	return name
+}
+
+// Map the name of a stored program like `db.name` or `name`, names not found are mapped by the
+// dictionary if any
+func (m *NameMap) Routine(from string) string {
+	from = strings.ToLower(from)
+	db, name := strings.ToLower(m.currentDB), from
+	if tokens := strings.SplitN(from, ".", 2); len(tokens) == 2 {
+		db, name = tokens[0], tokens[1]
+	}
+
+	to := name
+	if mapped, ok := m.Routines[fmt.Sprintf("%s.%s", db, name)]; ok {
+		tokens := strings.Split(mapped, ".")
+		to = tokens[len(tokens)-1]
+	} else {
+		m.miss("routine", fmt.Sprintf("%s.%s", db, name))
+		if m.dict != nil {
+			to = m.dict.Map(name)
+		}
+	}
+	if strings.Contains(from, ".") {
+		return fmt.Sprintf("%s.%s", m.DB(db), to)
+	}
+	return to
+}
+
+func isKeyConstraint(tp ast.ConstraintType) bool {
+	return tp != ast.ConstraintForeignKey && tp != ast.ConstraintCheck
+}
+
+func (m *NameMap) constraint(table string, c *ast.Constraint) {
+	if isKeyConstraint(c.Tp) {
+		c.Name = m.Index(table, c.Name)
+	} else {
+		c.Name = m.Constraint(table, c.Name)
+	}
+}
+
+func (m *NameMap) partitionDefinitions(table string, defs []*ast.PartitionDefinition) {
+	for _, def := range defs {
+		def.Name = model.NewCIStr(m.Partition(table, def.Name.O))
+		for _, sub := range def.Sub {
+			sub.Name = model.NewCIStr(m.Partition(table, sub.Name.O))
+		}
+	}
+}
+
+// Map names of indexes, constraints, partitions and columns of views defined or referred to in a
+// DDL statement, which must be done before names of tables in it are mapped
+func (m *NameMap) tableObjects(node ast.Node) {
+	tableOf := func(name *ast.TableName) string {
+		if name.Schema.L == "" {
+			return name.Name.L
+		}
+		return fmt.Sprintf("%s.%s", name.Schema.L, name.Name.L)
+	}
+
+	switch node := node.(type) {
+	case *ast.CreateTableStmt:
+		table := tableOf(node.Table)
+		for _, c := range node.Constraints {
+			m.constraint(table, c)
+		}
+		if node.Partition != nil {
+			m.partitionDefinitions(table, node.Partition.Definitions)
+		}
+	case *ast.AlterTableStmt:
+		table := tableOf(node.Table)
+		for _, spec := range node.Specs {
+			switch spec.Tp {
+			case ast.AlterTableDropIndex:
+				spec.Name = m.Index(table, spec.Name)
+			case ast.AlterTableDropForeignKey:
+				spec.Name = m.Constraint(table, spec.Name)
+			case ast.AlterTableRenameIndex:
+				spec.FromKey = model.NewCIStr(m.Index(table, spec.FromKey.O))
+				spec.ToKey = model.NewCIStr(m.Index(table, spec.ToKey.O))
+			case ast.AlterTableIndexInvisible:
+				spec.IndexName = model.NewCIStr(m.Index(table, spec.IndexName.O))
+			case ast.AlterTableDropCheck, ast.AlterTableAlterCheck:
+				// the constraint is of no type
+				spec.Constraint.Name = m.Constraint(table, spec.Constraint.Name)
+			case ast.AlterTableAddConstraint:
+				m.constraint(table, spec.Constraint)
+			}
+			for i := range spec.PartitionNames {
+				spec.PartitionNames[i] = model.NewCIStr(m.Partition(table, spec.PartitionNames[i].O))
+			}
+			m.partitionDefinitions(table, spec.PartDefinitions)
+			if spec.Partition != nil {
+				m.partitionDefinitions(table, spec.Partition.Definitions)
+			}
+		}
+	case *ast.CreateViewStmt:
+		// columns of the view are not `ColumnName`s
+		view := tableOf(node.ViewName)
+		if m.currentDB != "" && node.ViewName.Schema.L == "" {
+			view = fmt.Sprintf("%s.%s", m.currentDB, view)
+		}
+		for i, col := range node.Cols {
+			tokens := strings.Split(m.column(fmt.Sprintf("%s.%s", view, col.L)), ".")
+			node.Cols[i] = model.NewCIStr(tokens[len(tokens)-1])
+		}
+	case *ast.CreateIndexStmt:
+		node.IndexName = m.Index(tableOf(node.Table), node.IndexName)
+	case *ast.DropIndexStmt:
+		node.IndexName = m.Index(tableOf(node.Table), node.IndexName)
+	}
+}
+
+// Pair names of indexes, constraints and partitions of `from` on `table` with ones of `to` on
+// `mTable` by position, where tables are like `db.table`
+func (m *NameMap) PairTableObjects(table string, from *ast.CreateTableStmt, mTable string, to *ast.CreateTableStmt) {
+	pair := func(objects map[string]string, name string, mName string) {
+		if name != "" && mName != "" {
+			objects[strings.ToLower(fmt.Sprintf("%s.%s", table, name))] = strings.ToLower(fmt.Sprintf("%s.%s", mTable, mName))
+		}
+	}
+
+	for i, c := range from.Constraints {
+		if i >= len(to.Constraints) || to.Constraints[i].Tp != c.Tp {
+			break
+		}
+		if isKeyConstraint(c.Tp) {
+			pair(m.Indexes, c.Name, to.Constraints[i].Name)
+		} else {
+			pair(m.Constraints, c.Name, to.Constraints[i].Name)
+		}
+	}
+	if from.Partition != nil && to.Partition != nil {
+		for i, def := range from.Partition.Definitions {
+			if i < len(to.Partition.Definitions) {
+				pair(m.Partitions, def.Name.O, to.Partition.Definitions[i].Name.O)
+			}
+		}
+	}
+}
+
+// Schemes of names generated by `NameGenerator`
+const (
+	// hashed names like `_h1y98qyh` by `dict.Dictionary`, the same as names not found in a map
+	NameSchemeDict = "dict"
+	// numbered names like `db0.table3.col7` in order of addition
+	NameSchemeIndex = "index"
+)
+
+// Generates masked names for an original schema, so that no pre-masked schema is required
+type NameGenerator struct {
+	scheme  string
+	dict    *dict.Dictionary
+	nameMap *NameMap
+	counts  map[string]int // number of names of each kind in each masked scope
+}
+
+func NewNameGenerator(scheme string) (*NameGenerator, error) {
+	scheme = strings.ToLower(scheme)
+	if scheme != NameSchemeDict && scheme != NameSchemeIndex {
+		return nil, fmt.Errorf("no such name scheme `%s`, available schemes are `%v`", scheme, []string{NameSchemeDict, NameSchemeIndex})
+	}
+	return &NameGenerator{
+		scheme:  scheme,
+		dict:    NewDefaultDictionary(),
+		nameMap: NewGlobalNameMap(map[string]string{}),
+		counts:  map[string]int{},
+	}, nil
+}
+
+// Generate names for the table, view or sequence created by `stmt` in database `db` and its
+// columns `cols`, also for indexes, constraints and partitions of a table. Returns the masked
+// `db.table`.
+func (g *NameGenerator) Add(db string, stmt ast.StmtNode, cols []string) (string, error) {
+	var table, kind string
+	switch stmt := stmt.(type) {
+	case *ast.CreateTableStmt:
+		table, kind = stmt.Table.Name.L, "table"
+	case *ast.CreateViewStmt:
+		table, kind = stmt.ViewName.Name.L, "view"
+	case *ast.CreateSequenceStmt:
+		table, kind = stmt.Name.Name.L, "seq"
+	default:
+		return "", fmt.Errorf("no names to generate for `%s`", restoreNode(stmt))
+	}
+	db = strings.ToLower(db)
+	m := g.nameMap
+
+	mDB, ok := m.DBs[db]
+	if !ok {
+		mDB = g.name("db", "", db)
+		m.DBs[db] = mDB
+	}
+	fullTable := fmt.Sprintf("%s.%s", db, table)
+	mFullTable := fmt.Sprintf("%s.%s", mDB, g.name(kind, mDB, table))
+	m.Tables[fullTable] = mFullTable
+
+	add := func(objects map[string]string, kind string, name string) {
+		name = strings.ToLower(name)
+		objects[fmt.Sprintf("%s.%s", fullTable, name)] = fmt.Sprintf("%s.%s", mFullTable, g.name(kind, mFullTable, name))
+	}
+	for _, col := range cols {
+		add(m.Columns, "col", col)
+	}
+	if create, ok := stmt.(*ast.CreateTableStmt); ok {
+		for _, c := range create.Constraints {
+			if c.Name == "" || strings.EqualFold(c.Name, "primary") {
+				continue
+			}
+			if isKeyConstraint(c.Tp) {
+				add(m.Indexes, "idx", c.Name)
+			} else {
+				add(m.Constraints, "cons", c.Name)
+			}
+		}
+		if create.Partition != nil {
+			for _, def := range create.Partition.Definitions {
+				add(m.Partitions, "part", def.Name.O)
+				for _, sub := range def.Sub {
+					add(m.Partitions, "part", sub.Name.O)
+				}
+			}
+		}
+	}
+	return mFullTable, nil
+}
+
+// Name `from` as the next of its `kind` in `scope` in the scheme
+func (g *NameGenerator) name(kind string, scope string, from string) string {
+	key := fmt.Sprintf("%s@%s", kind, scope)
+	index := g.counts[key]
+	g.counts[key] += 1
+
+	if g.scheme == NameSchemeIndex {
+		return fmt.Sprintf("%s%d", kind, index)
+	}
+	return g.dict.Map(from)
+}
+
+// The global `NameMap` of all names generated
+func (g *NameGenerator) NameMap() *NameMap {
+	return g.nameMap
+}
@@ -3,6 +3,8 @@ package mask
 import (
 	"testing"
 
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/tidb/expression"
 	"github.com/stretchr/testify/require"
 )
@@ -35,3 +37,134 @@ func TestNameMap(t *testing.T) {
 	require.Equal(t, local.table("test.t"), "db0.table0")
 	require.Equal(t, local.table("t"), "table0")
 }
+
+func TestDefaultDictionaryWithSecret(t *testing.T) {
+	defer SetSecret(nil)
+
+	names := []string{"test", "t1", "id"}
+	mapAll := func() []string {
+		d := NewDefaultDictionary()
+		mapped := []string{}
+		for _, name := range names {
+			mapped = append(mapped, d.Map(name))
+		}
+		return mapped
+	}
+	unkeyed := mapAll()
+
+	SetSecret([]byte("s3cr3t"))
+	keyed := mapAll()
+	require.Equal(t, keyed, mapAll()) // deterministic for the same secret
+	for i := range names {
+		require.NotEqual(t, unkeyed[i], keyed[i])
+	}
+
+	SetSecret([]byte("an0ther"))
+	for i, mapped := range mapAll() {
+		require.NotEqual(t, keyed[i], mapped)
+	}
+
+	// generated names are keyed as well
+	g, err := NewNameGenerator(NameSchemeDict)
+	require.Nil(t, err)
+	stmt, err := parser.New().ParseOneStmt("CREATE TABLE t1 (id INT)", "", "")
+	require.Nil(t, err)
+	mapped, err := g.Add("test", stmt, []string{"id"})
+	require.Nil(t, err)
+	require.NotEqual(t, "_h1ko4a3y._h1y98qyh", mapped)
+
+	SetSecret(nil)
+	require.Equal(t, unkeyed, mapAll())
+}
+
+func TestNameGenerator(t *testing.T) {
+	t.Parallel()
+
+	parse := func(sql string) ast.StmtNode {
+		node, err := parser.New().ParseOneStmt(sql, "", "")
+		require.Nil(t, err)
+		return node
+	}
+
+	_, err := NewNameGenerator("no-such-scheme")
+	require.NotNil(t, err)
+
+	g, err := NewNameGenerator(NameSchemeIndex)
+	require.Nil(t, err)
+	for _, c := range []struct {
+		db     string
+		sql    string
+		cols   []string
+		mapped string
+	}{
+		{"test", "CREATE TABLE warehouse (w_id INT PRIMARY KEY, w_name VARCHAR(10))", []string{"w_id", "w_name"}, "db0.table0"},
+		{"test", "CREATE TABLE district (d_id INT, d_w_id INT, PRIMARY KEY (d_w_id, d_id), KEY idx_w (d_w_id), CONSTRAINT fk_w FOREIGN KEY (d_w_id) REFERENCES warehouse (w_id)) PARTITION BY HASH (d_id) (PARTITION p0, PARTITION p1)", []string{"d_id", "d_w_id"}, "db0.table1"},
+		{"test", "CREATE VIEW v AS SELECT w_id FROM warehouse", []string{"w_id"}, "db0.view0"},
+		{"test", "CREATE SEQUENCE s", nil, "db0.seq0"},
+		{"other", "CREATE TABLE t (id INT)", []string{"id"}, "db1.table0"},
+		{"namegen", "CREATE TABLE t (id INT DEFAULT 5 COMMENT 'secret', KEY (id))", []string{"id"}, "db2.table0"},
+	} {
+		mapped, err := g.Add(c.db, parse(c.sql), c.cols)
+		require.Nil(t, err)
+		require.Equal(t, c.mapped, mapped)
+	}
+	_, err = g.Add("test", parse("CREATE DATABASE test"), nil)
+	require.NotNil(t, err)
+
+	global := g.NameMap()
+	require.Equal(t, "db0.table1.col1", global.Columns["test.district.d_w_id"])
+	require.Equal(t, "db0.table1.idx0", global.Indexes["test.district.idx_w"])
+	require.Equal(t, "db0.table1.cons0", global.Constraints["test.district.fk_w"])
+	require.Equal(t, "db0.table1.part1", global.Partitions["test.district.p1"])
+	require.Equal(t, "db0.seq0", global.Tables["test.s"])
+	require.Equal(t, "db1", global.DB("other"))
+
+	// schemas are masked with the generated map like other DDLs
+	w := NewSQLWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, global)
+	_, err = w.MaskOne("CREATE DATABASE namegen")
+	require.Nil(t, err)
+	masked, err := w.MaskOne("CREATE TABLE namegen.t (id INT DEFAULT 5 COMMENT 'secret', KEY (id))")
+	require.Nil(t, err)
+	require.Equal(t, "CREATE TABLE `db2`.`table0` (`col0` INT(11) DEFAULT 'int(11) 5' COMMENT 'var_string(5) secret',INDEX(`col0`))", masked)
+
+	g, err = NewNameGenerator(NameSchemeDict)
+	require.Nil(t, err)
+	mapped, err := g.Add("test", parse("CREATE TABLE t1 (id INT)"), []string{"id"})
+	require.Nil(t, err)
+	require.Equal(t, "_h1ko4a3y._h1y98qyh", mapped)
+	// the same as names not found in a map
+	local, _ := NewLocalNameMap(g.NameMap(), nil, "test")
+	require.Equal(t, "_h1y98qyh", local.column("t1"))
+}
+
+func TestNameMapObjects(t *testing.T) {
+	t.Parallel()
+
+	global := NewGlobalNameMap(map[string]string{
+		"test.t.a": "db0.table0.col0",
+		"test.t.b": "db0.table0.col1",
+	})
+	global.Indexes["test.t.idx_a"] = "db0.table0.idx0"
+	global.Constraints["test.t.chk_b"] = "db0.table0.cons0"
+	global.Partitions["test.t.p0"] = "db0.table0.part0"
+	global.Variables["customer_id"] = "var0"
+	local, _ := NewLocalNameMap(global, []*expression.Column{{OrigName: "test.t.a"}, {OrigName: "test.t.b"}}, "test")
+
+	cases := []struct {
+		sql    string
+		mapped string
+	}{
+		{"SELECT a FROM t USE INDEX (idx_a, PRIMARY) WHERE b = @customer_id", "SELECT `col0` FROM `table0` USE INDEX (`idx0`, `PRIMARY`) WHERE `col1`=@`var0`"},
+		{"SELECT /*+ USE_INDEX(t idx_a) */ a FROM t PARTITION (p0)", "SELECT /*+ USE_INDEX(`table0` `idx0`)*/ `col0` FROM `table0` PARTITION(`part0`)"},
+		{"SELECT @unknown", "SELECT @`_hldgmah`"},
+		{"ALTER TABLE t DROP INDEX idx_a, DROP CHECK chk_b, TRUNCATE PARTITION p0", "ALTER TABLE `table0` DROP INDEX `idx0`, DROP CHECK `cons0`, TRUNCATE PARTITION `part0`"},
+		{"CREATE INDEX idx_a ON t (a)", "CREATE INDEX `idx0` ON `table0` (`col0`)"},
+		{"DROP INDEX idx_a ON test.t", "DROP INDEX `idx0` ON `db0`.`table0`"},
+	}
+	for _, c := range cases {
+		node, err := parser.New().ParseOneStmt(c.sql, "", "")
+		require.Nil(t, err)
+		newNode, _ := node.Accept(NewNameOnlyRestoreVisitor(local))
+		require.Equal(t, c.mapped, restoreNode(newNode), c.sql)
+	}
+}
@@ -0,0 +1,125 @@
+package mask
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/BugenZhao/sql-masker/mask/funcs"
+	"gopkg.in/yaml.v2"
+)
+
+// Aliases of mask functions for policies
+var maskFuncAliases = map[string]string{
+	"keep": "identical",
+	"hash": "workload-sim",
+}
+
+// Resolve a mask function from `spec`, which is one of `keep`, `hash`, `null`, `fixed:<value>`
+// or any name in `MaskFuncMap`
+func ResolveMaskFunc(spec string) (MaskFunc, error) {
+	if strings.HasPrefix(spec, "fixed:") {
+		value := strings.TrimPrefix(spec, "fixed:")
+		return MaskFunc{fmt.Sprintf("Replace with fixed value `%s`", value), funcs.NewFixedMask(value), nil}, nil
+	}
+
+	name := strings.ToLower(spec)
+	if name == "null" {
+		return MaskFunc{"Replace with NULL", funcs.NullMask, nil}, nil
+	}
+	if alias, ok := maskFuncAliases[name]; ok {
+		name = alias
+	}
+	if fn, ok := MaskFuncMap[name]; ok {
+		return fn, nil
+	}
+	return MaskFunc{}, fmt.Errorf("no such mask function `%s`", spec)
+}
+
+// A rule in `MaskPolicy`, constants inferred against columns matching `Column` will be masked
+// with `Mask`. `Column` is a pattern of `db.table.column`, where each part may contain globs,
+// and the leading parts may be omitted, like `*.c_phone`.
+type MaskRule struct {
+	Column string `json:"column" yaml:"column"`
+	Mask   string `json:"mask" yaml:"mask"`
+
+	maskFunc MaskFunc
+}
+
+// A per-column mask policy, rules are matched in order. Constants whose columns are unknown or
+// not matched by any rule fall back to `Default`, or the global mask function if not given.
+type MaskPolicy struct {
+	Default string     `json:"default" yaml:"default"`
+	Rules   []MaskRule `json:"rules" yaml:"rules"`
+
+	defaultFunc *MaskFunc
+}
+
+// Parse a policy in YAML or JSON
+func ParseMaskPolicy(bytes []byte) (*MaskPolicy, error) {
+	policy := &MaskPolicy{}
+	err := yaml.UnmarshalStrict(bytes, policy)
+	if err != nil {
+		return nil, fmt.Errorf("bad mask policy format; %w", err)
+	}
+
+	if policy.Default != "" {
+		fn, err := ResolveMaskFunc(policy.Default)
+		if err != nil {
+			return nil, err
+		}
+		policy.defaultFunc = &fn
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if strings.Count(rule.Column, ".") > 2 {
+			return nil, fmt.Errorf("bad column pattern `%s` in mask policy", rule.Column)
+		}
+		if rule.Mask == "" {
+			return nil, fmt.Errorf("no mask function given for column `%s`, note that `null` should be quoted in YAML", rule.Column)
+		}
+		rule.maskFunc, err = ResolveMaskFunc(rule.Mask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return policy, nil
+}
+
+// Match `db.table.column` against `pattern` part by part
+func matchColumnPattern(pattern string, column string) bool {
+	patternTokens := strings.Split(strings.ToLower(pattern), ".")
+	columnTokens := strings.Split(strings.ToLower(column), ".")
+	if len(patternTokens) > len(columnTokens) {
+		return false
+	}
+
+	columnTokens = columnTokens[len(columnTokens)-len(patternTokens):]
+	for i := range patternTokens {
+		if ok, _ := path.Match(patternTokens[i], columnTokens[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Find the mask function for a constant with inferred type `tp`
+func (p *MaskPolicy) Resolve(tp *InferredType, fallback MaskFunc) MaskFunc {
+	if p == nil {
+		return fallback
+	}
+
+	if column := tp.ColumnName(); column != "" {
+		for _, rule := range p.Rules {
+			if matchColumnPattern(rule.Column, column) {
+				return rule.maskFunc
+			}
+		}
+	}
+
+	if p.defaultFunc != nil {
+		return *p.defaultFunc
+	}
+	return fallback
+}
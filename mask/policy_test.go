@@ -0,0 +1,60 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := ParseMaskPolicy([]byte(`
+default: hash
+rules:
+  - column: test.customer.c_last
+    mask: format-preserving
+  - column: "*.c_id"
+    mask: keep
+  - column: test.customer.c_balance
+    mask: "null"
+  - column: c_w_*
+    mask: fixed:7
+`))
+	require.Nil(t, err)
+
+	columnType := func(origName string) *InferredType {
+		col := &expression.Column{OrigName: origName, RetType: types.NewFieldType(mysql.TypeLonglong)}
+		return NewColumnInferredType(col)
+	}
+	fallback := MaskFuncMap["debug"]
+
+	tests := []struct {
+		tp       *InferredType
+		expected string
+	}{
+		{columnType("test.customer.c_last"), MaskFuncMap["format-preserving"].Description},
+		{columnType("TEST.Customer.C_LAST"), MaskFuncMap["format-preserving"].Description},
+		{columnType("test.district.c_id"), MaskFuncMap["identical"].Description},
+		{columnType("test.customer.c_balance"), "Replace with NULL"},
+		{columnType("test.customer.c_w_id"), "Replace with fixed value `7`"},
+		{columnType("test.customer.c_first"), MaskFuncMap["workload-sim"].Description},
+		{NewInferredType(types.NewFieldType(mysql.TypeLonglong)), MaskFuncMap["workload-sim"].Description},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, policy.Resolve(test.tp, fallback).Description, test.tp.ColumnName())
+	}
+
+	var nilPolicy *MaskPolicy
+	require.Equal(t, fallback.Description, nilPolicy.Resolve(columnType("test.t.a"), fallback).Description)
+
+	_, err = ParseMaskPolicy([]byte(`{"rules": [{"column": "a.b.c.d", "mask": "keep"}]}`))
+	require.NotNil(t, err)
+	_, err = ParseMaskPolicy([]byte(`{"rules": [{"column": "a", "mask": "no-such-func"}]}`))
+	require.NotNil(t, err)
+	_, err = ParseMaskPolicy([]byte("rules:\n  - column: a\n    mask: null\n"))
+	require.NotNil(t, err)
+}
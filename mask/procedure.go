@@ -0,0 +1,605 @@
+package mask
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// Stored programs are not supported by the parser, so they're recognized by their headers
+var storedProgramRegexp = regexp.MustCompile("(?is)^\\s*(CREATE|DROP|ALTER)\\s+(?:DEFINER\\s*=\\s*\\S+\\s+)?(PROCEDURE|FUNCTION|TRIGGER|EVENT)\\s+(?:IF\\s+(?:NOT\\s+)?EXISTS\\s+)?([`\\w$.]+)")
+
+// A stored program statement like `CREATE PROCEDURE`, whose body can't be analyzed
+type storedProgram struct {
+	action string // `CREATE`, `DROP` or `ALTER` in upper case
+	kind   string // `PROCEDURE`, `FUNCTION`, `TRIGGER` or `EVENT` in upper case
+	name   string // `db.name` in lower case
+	// the statement before the name, and whether the name is qualified by the database
+	head      string
+	qualified bool
+	// the rest of the statement after the name
+	rest string
+}
+
+func parseStoredProgram(sql string, currentDB string) (*storedProgram, bool) {
+	match := storedProgramRegexp.FindStringSubmatchIndex(sql)
+	if match == nil {
+		return nil, false
+	}
+	name := strings.ToLower(strings.ReplaceAll(sql[match[6]:match[7]], "`", ""))
+	qualified := strings.Contains(name, ".")
+	if !qualified {
+		name = fmt.Sprintf("%s.%s", strings.ToLower(currentDB), name)
+	}
+	return &storedProgram{
+		action:    strings.ToUpper(sql[match[2]:match[3]]),
+		kind:      strings.ToUpper(sql[match[4]:match[5]]),
+		name:      name,
+		head:      sql[:match[6]],
+		qualified: qualified,
+		rest:      sql[match[1]:],
+	}, true
+}
+
+// Split the parenthesized list at the beginning of `s` by top-level commas, returns the items
+// and the rest after the list
+func splitParenthesized(s string) ([]string, string, error) {
+	s = strings.TrimLeft(s, " \t\r\n")
+	if !strings.HasPrefix(s, "(") {
+		return nil, s, fmt.Errorf("parameter list not found")
+	}
+
+	items := []string{}
+	depth := 0
+	var quote byte
+	begin := 1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i += 1
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth += 1
+		case c == ',' && depth == 1:
+			items = append(items, s[begin:i])
+			begin = i + 1
+		case c == ')':
+			depth -= 1
+			if depth == 0 {
+				if item := strings.TrimSpace(s[begin:i]); item != "" || len(items) > 0 {
+					items = append(items, item)
+				}
+				return items, s[i+1:], nil
+			}
+		}
+	}
+	return nil, s, fmt.Errorf("unclosed parameter list")
+}
+
+// Parse types of parameters of a `CREATE PROCEDURE` like `(IN id INT, OUT name VARCHAR(20))`
+func (w *worker) parseProcedureParams(rest string) ([]*types.FieldType, error) {
+	params, _, err := splitParenthesized(rest)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	// types are parsed as columns of a table
+	columns := make([]string, 0, len(params))
+	for i, param := range params {
+		tokens := strings.Fields(param)
+		if len(tokens) > 0 {
+			switch strings.ToUpper(tokens[0]) {
+			case "IN", "OUT", "INOUT":
+				tokens = tokens[1:]
+			}
+		}
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("bad parameter `%s`", param)
+		}
+		columns = append(columns, fmt.Sprintf("`p%d` %s", i, strings.Join(tokens[1:], " ")))
+	}
+	node, err := w.parseOne(fmt.Sprintf("CREATE TABLE `params` (%s)", strings.Join(columns, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	tblInfo, err := ddl.BuildTableInfoFromAST(node.(*ast.CreateTableStmt))
+	if err != nil {
+		return nil, err
+	}
+
+	fts := make([]*types.FieldType, 0, len(params))
+	for _, col := range tblInfo.Cols() {
+		fts = append(fts, col.FieldType.Clone())
+	}
+	return fts, nil
+}
+
+// Mask a stored program statement, which can't be parsed. Types of parameters of procedures
+// are learned for `CALL`, while the rest is masked lexically, see `maskProgramBody`. Bodies are
+// never analyzed, so that creating a stored program is always problematic.
+func (w *worker) maskStoredProgram(sql string, p *storedProgram) (string, []InferredConstant, error) {
+	switch {
+	case p.kind == "PROCEDURE" && p.action == "CREATE":
+		params, err := w.parseProcedureParams(p.rest)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse parameters of procedure `%s`; %w", p.name, err)
+		}
+		w.procedures[p.name] = params
+	case p.kind == "PROCEDURE" && p.action == "DROP":
+		delete(w.procedures, p.name)
+	}
+
+	db := strings.SplitN(p.name, ".", 2)[0]
+	localNameMap, err := NewLocalNameMap(w.globalNameMap, nil, db)
+	if err != nil {
+		return "", nil, err
+	}
+	name := p.name
+	if !p.qualified {
+		name = strings.SplitN(p.name, ".", 2)[1]
+	}
+	// calls of stored functions can't be told from builtin functions, so they're never mapped
+	if localNameMap != nil && p.kind != "FUNCTION" {
+		name = localNameMap.Routine(name)
+	}
+
+	body, err := w.maskProgramBody(p, db, localNameMap)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := w.checkUnmapped(localNameMap); err != nil {
+		return "", nil, err
+	}
+
+	newSQL := p.head + quoteName(name) + body
+	if p.action == "CREATE" {
+		err := fmt.Errorf("body of %s `%s` is not analyzed, literals are masked with their own types", strings.ToLower(p.kind), p.name)
+		return fmt.Sprintf("/* PROBLEMATIC: %v */ %s", err, newSQL), nil, err
+	}
+	return newSQL, nil, nil
+}
+
+// Quote a name like `db.name` by parts
+func quoteName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = fmt.Sprintf("`%s`", strings.ReplaceAll(part, "`", "``"))
+	}
+	return strings.Join(parts, ".")
+}
+
+// Names of types whose parenthesized arguments like `DECIMAL(10, 2)` are kept as is
+var typeNames = map[string]bool{
+	"BIT": true, "TINYINT": true, "SMALLINT": true, "MEDIUMINT": true, "INT": true, "INTEGER": true,
+	"BIGINT": true, "DECIMAL": true, "DEC": true, "NUMERIC": true, "FIXED": true, "FLOAT": true,
+	"DOUBLE": true, "REAL": true, "CHAR": true, "VARCHAR": true, "NCHAR": true, "NVARCHAR": true,
+	"BINARY": true, "VARBINARY": true, "TIME": true, "DATETIME": true, "TIMESTAMP": true,
+	"YEAR": true, "TEXT": true, "BLOB": true, "ENUM": true, "SET": true,
+}
+
+// Keywords followed by structural literals, like limits, intervals, error codes, SQL states and
+// schedules of events
+var structuralKeywords = map[string]bool{
+	"LIMIT": true, "OFFSET": true, "INTERVAL": true, "HANDLER": true, "SQLSTATE": true,
+	"EVERY": true, "AT": true, "STARTS": true, "ENDS": true,
+}
+
+// Mask the rest of a stored program after its name lexically. Strings and numbers are masked
+// with their own types, except arguments of types and structural literals. Names of tables are
+// mapped, so are names of columns qualified by tables or found in tables referred to.
+func (w *worker) maskProgramBody(p *storedProgram, db string, nameMap *NameMap) (string, error) {
+	tokens := lexSQL(p.rest)
+	var names *programNames
+	if nameMap != nil {
+		// bodies are never planned, so that columns are mapped by full names
+		nameMap.Columns = w.globalNameMap.Columns
+		names = newProgramNames(p, db, nameMap, tokens)
+	}
+
+	sb := strings.Builder{}
+	var prev *lexToken
+	depth, typeDepth := 0, 0
+	structural := false
+	for i := 0; i < len(tokens); i++ {
+		tk := &tokens[i]
+		if tk.kind == lexSpace {
+			_, _ = sb.WriteString(tk.text)
+			continue
+		}
+
+		upper := ""
+		if tk.kind == lexName {
+			upper = strings.ToUpper(tk.text)
+		}
+		switch {
+		case structuralKeywords[upper]:
+			structural = true
+		case structural && (tk.kind == lexString || tk.kind == lexNumber || tk.text == "," || upper == "FOR" || upper == "VALUE"):
+		default:
+			structural = false
+		}
+		// like `@var` or `@'var'`, but not `@@var`
+		variable := i > 0 && tokens[i-1].text == "@" && (i == 1 || tokens[i-2].text != "@")
+
+		switch {
+		case (tk.kind == lexString || tk.kind == lexNumber) && !variable:
+			if typeDepth > 0 || structural {
+				_, _ = sb.WriteString(tk.text)
+				break
+			}
+			masked, err := w.maskLexical(tk)
+			if err != nil {
+				return "", err
+			}
+			if strings.HasPrefix(masked, "-") && strings.HasSuffix(sb.String(), "-") {
+				_ = sb.WriteByte(' ')
+			}
+			_, _ = sb.WriteString(masked)
+		case tk.kind == lexName && names != nil && variable:
+			_, _ = sb.WriteString(quoteName(nameMap.Variable(tk.value)))
+		case tk.kind == lexName && names != nil:
+			end := chainEnd(tokens, i)
+			parts := []string{}
+			for j := i; j <= end; j += 2 {
+				parts = append(parts, tokens[j].value)
+			}
+			call := end+1 < len(tokens) && tokens[end+1].text == "("
+			mapped := names.mapNames(parts, call)
+			for j := i; j <= end; j += 2 {
+				if j > i {
+					_ = sb.WriteByte('.')
+				}
+				if mapped == nil || mapped[(j-i)/2] == "" {
+					_, _ = sb.WriteString(tokens[j].text)
+				} else {
+					_, _ = sb.WriteString(quoteName(mapped[(j-i)/2]))
+				}
+			}
+			tk = &tokens[end]
+			i = end
+		case tk.text == "(":
+			depth += 1
+			if typeDepth == 0 && prev != nil && prev.kind == lexName && typeNames[strings.ToUpper(prev.text)] {
+				typeDepth = depth
+			}
+			_, _ = sb.WriteString(tk.text)
+		case tk.text == ")":
+			if depth == typeDepth {
+				typeDepth = 0
+			}
+			depth -= 1
+			_, _ = sb.WriteString(tk.text)
+		default:
+			_, _ = sb.WriteString(tk.text)
+		}
+		prev = tk
+	}
+	return sb.String(), nil
+}
+
+// Mask a string or number token with its own type
+func (w *worker) maskLexical(tk *lexToken) (string, error) {
+	datum := types.NewStringDatum(tk.value)
+	if tk.kind == lexNumber {
+		var err error
+		if datum, err = numberDatum(tk.text); err != nil {
+			return "", err
+		}
+	}
+	maskedDatum, _, masked, err := w.mask(datum, ownInferredType(datum))
+	if err != nil {
+		return "", err
+	}
+	if !masked {
+		maskedDatum = datum
+	}
+	return restoreNode(ast.NewValueExpr(maskedDatum.GetValue(), "", "")), nil
+}
+
+// Parse a number literal like `42`, `1.5` or `1e3`
+func numberDatum(text string) (types.Datum, error) {
+	if !strings.ContainsAny(text, ".eE") {
+		if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return types.NewIntDatum(v), nil
+		}
+		if v, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return types.NewUintDatum(v), nil
+		}
+	}
+	if strings.ContainsAny(text, "eE") {
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return types.Datum{}, err
+		}
+		return types.NewFloat64Datum(v), nil
+	}
+	dec := new(types.MyDecimal)
+	if err := dec.FromString([]byte(text)); err != nil {
+		return types.Datum{}, err
+	}
+	return types.NewDecimalDatum(dec), nil
+}
+
+// Names referred to by the body of a stored program
+type programNames struct {
+	nameMap *NameMap
+	db      string
+	// tables referred to like `db.table` in lower case, where columns are found by bare names
+	tables []string
+	// the table of a trigger, whose columns are referred to like `NEW.col`
+	trigger string
+}
+
+var triggerTableRegexp = regexp.MustCompile("(?is)^\\s*(?:BEFORE|AFTER)\\s+(?:INSERT|UPDATE|DELETE)\\s+ON\\s+([`\\w$.]+)")
+
+func newProgramNames(p *storedProgram, db string, nameMap *NameMap, tokens []lexToken) *programNames {
+	names := &programNames{nameMap: nameMap, db: db}
+	if p.kind == "TRIGGER" {
+		if match := triggerTableRegexp.FindStringSubmatch(p.rest); match != nil {
+			names.trigger = strings.ToLower(strings.ReplaceAll(match[1], "`", ""))
+			if !strings.Contains(names.trigger, ".") {
+				names.trigger = fmt.Sprintf("%s.%s", db, names.trigger)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].kind != lexName {
+			continue
+		}
+		end := chainEnd(tokens, i)
+		parts := []string{}
+		for j := i; j <= end && len(parts) < 2; j += 2 {
+			parts = append(parts, tokens[j].value)
+		}
+		for n := len(parts); n > 0; n-- {
+			if table, ok := names.table(parts[:n]); ok && !seen[table] {
+				seen[table] = true
+				names.tables = append(names.tables, table)
+			}
+		}
+		i = end
+	}
+	return names
+}
+
+// Find the table named by `parts` like `table` or `db.table`
+func (n *programNames) table(parts []string) (string, bool) {
+	var table string
+	switch len(parts) {
+	case 1:
+		table = fmt.Sprintf("%s.%s", n.db, strings.ToLower(parts[0]))
+	case 2:
+		table = strings.ToLower(strings.Join(parts, "."))
+	default:
+		return "", false
+	}
+	_, ok := n.nameMap.Tables[table]
+	return table, ok
+}
+
+// Find the column of a bare name in tables referred to
+func (n *programNames) column(name string) (string, bool) {
+	for _, table := range n.tables {
+		column := fmt.Sprintf("%s.%s", table, strings.ToLower(name))
+		if _, ok := n.nameMap.Columns[column]; ok {
+			return column, true
+		}
+	}
+	return "", false
+}
+
+// Map names like `t.col`, returns mapped names or empty ones for names kept, or nil if all are
+// kept. Names of functions in `call` are never mapped as columns.
+func (n *programNames) mapNames(parts []string, call bool) []string {
+	lastOf := func(name string) string {
+		tokens := strings.Split(name, ".")
+		return tokens[len(tokens)-1]
+	}
+
+	if _, ok := n.table(parts); ok {
+		return strings.Split(n.nameMap.table(strings.Join(parts, ".")), ".")
+	}
+	name := parts[len(parts)-1]
+	switch len(parts) {
+	case 1:
+		if column, ok := n.column(name); ok && !call {
+			return []string{lastOf(n.nameMap.column(column))}
+		}
+	case 2:
+		qualifier := strings.ToLower(parts[0])
+		if (qualifier == "new" || qualifier == "old") && n.trigger != "" {
+			return []string{"", lastOf(n.nameMap.column(fmt.Sprintf("%s.%s", n.trigger, name)))}
+		}
+		if table, ok := n.table(parts[:1]); ok {
+			return []string{n.nameMap.table(parts[0]), lastOf(n.nameMap.column(fmt.Sprintf("%s.%s", table, name)))}
+		}
+		// qualified by an alias
+		if column, ok := n.column(name); ok {
+			return []string{"", lastOf(n.nameMap.column(column))}
+		}
+	case 3:
+		if _, ok := n.table(parts[:2]); ok {
+			return strings.Split(n.nameMap.column(strings.Join(parts, ".")), ".")
+		}
+	}
+	return nil
+}
+
+type lexKind int
+
+const (
+	lexSpace lexKind = iota // whitespaces and comments
+	lexPunct
+	lexString
+	lexNumber
+	lexName // bare or quoted names, including keywords
+)
+
+type lexToken struct {
+	kind lexKind
+	text string
+	// unquoted values of strings and names
+	value string
+}
+
+var numberRegexp = regexp.MustCompile("^[0-9]+(?:\\.[0-9]*)?(?:[eE][+-]?[0-9]+)?")
+
+func isNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '$' || c >= 0x80
+}
+
+// Split `sql` into tokens, where names starting with digits like `1abc` are names
+func lexSQL(sql string) []lexToken {
+	tokens := []lexToken{}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		size := 1
+		tk := lexToken{kind: lexPunct}
+		switch {
+		case c == '\'' || c == '"':
+			tk.kind = lexString
+			tk.value, size = readQuoted(sql[i:])
+		case c == '`':
+			tk.kind = lexName
+			end := strings.IndexByte(sql[i+1:], '`')
+			for end >= 0 && i+end+2 < len(sql) && sql[i+end+2] == '`' {
+				next := strings.IndexByte(sql[i+end+3:], '`')
+				if next < 0 {
+					end = -1
+					break
+				}
+				end += next + 2
+			}
+			if end < 0 {
+				end = len(sql) - i - 2
+			}
+			size = end + 2
+			tk.value = strings.ReplaceAll(sql[i+1:i+1+end], "``", "`")
+		case c == '#' || strings.HasPrefix(sql[i:], "-- "):
+			tk.kind = lexSpace
+			if size = strings.IndexByte(sql[i:], '\n'); size < 0 {
+				size = len(sql) - i
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			tk.kind = lexSpace
+			if end := strings.Index(sql[i+2:], "*/"); end < 0 {
+				size = len(sql) - i
+			} else {
+				size = end + 4
+			}
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			tk.kind = lexSpace
+		case isNameByte(c):
+			tk.kind = lexName
+			for i+size < len(sql) && isNameByte(sql[i+size]) {
+				size += 1
+			}
+			if number := numberRegexp.FindString(sql[i:]); number != "" {
+				if end := i + len(number); end == len(sql) || !isNameByte(sql[end]) {
+					tk.kind = lexNumber
+					size = len(number)
+				}
+			}
+			tk.value = sql[i : i+size]
+		}
+		tk.text = sql[i : i+size]
+		tokens = append(tokens, tk)
+		i += size
+	}
+	return tokens
+}
+
+// Find the end of names joined by dots like `db.t.col` starting at `i`
+func chainEnd(tokens []lexToken, i int) int {
+	for i+2 < len(tokens) && tokens[i+1].text == "." && tokens[i+2].kind == lexName {
+		i += 2
+	}
+	return i
+}
+
+// Read a quoted string at the beginning of `s`, returns the unescaped value and the size read
+func readQuoted(s string) (string, int) {
+	quote := s[0]
+	sb := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i += 1
+			switch s[i] {
+			case 'n':
+				_ = sb.WriteByte('\n')
+			case 't':
+				_ = sb.WriteByte('\t')
+			case 'r':
+				_ = sb.WriteByte('\r')
+			case '0':
+				_ = sb.WriteByte(0)
+			default:
+				_ = sb.WriteByte(s[i])
+			}
+		case c == quote && i+1 < len(s) && s[i+1] == quote:
+			_ = sb.WriteByte(quote)
+			i += 1
+		case c == quote:
+			return sb.String(), i + 1
+		default:
+			_ = sb.WriteByte(c)
+		}
+	}
+	return sb.String(), len(s)
+}
+
+// Infer types of arguments of `CALL` from parameters of the procedure, since it's never compiled
+func (w *worker) inferCall(call *ast.CallStmt, replaced *ReplaceVisitor) (TypeMap, *NameMap, error) {
+	name := call.Procedure.FnName.L
+	if call.Procedure.Schema.L != "" {
+		name = fmt.Sprintf("%s.%s", call.Procedure.Schema.L, name)
+	} else {
+		name = fmt.Sprintf("%s.%s", strings.ToLower(w.db.CurrentDB()), name)
+	}
+	params, ok := w.procedures[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("procedure `%s` not found, it should be created before", name)
+	}
+	if len(params) != len(call.Procedure.Args) {
+		return nil, nil, fmt.Errorf("procedure `%s` has %d parameters, but %d arguments are given", name, len(params), len(call.Procedure.Args))
+	}
+
+	inferredTypes := make(TypeMap)
+	for i, arg := range call.Procedure.Args {
+		expr, ok := arg.(*driver.ValueExpr)
+		if !ok {
+			continue
+		}
+		m, ok := markerOf(expr.Datum)
+		if !ok {
+			continue
+		}
+		inferredTypes[m] = NewInferredType(params[i])
+	}
+	expandGroupTypes(replaced.Groups, inferredTypes)
+
+	localNameMap, err := NewLocalNameMap(w.globalNameMap, nil, w.db.CurrentDB())
+	if err != nil {
+		return nil, nil, err
+	}
+	return inferredTypes, localNameMap, nil
+}
@@ -0,0 +1,87 @@
+package mask
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskStoredPrograms(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+
+	// bodies are not analyzed, literals are masked with their own types except arguments of types
+	// and structural ones
+	result := w.MaskOneResult("CREATE DEFINER=`root`@`%` PROCEDURE `proc_bob`(IN id INT, name VARCHAR(20), OUT total DECIMAL(10, 2))\nBEGIN\n  DECLARE CONTINUE HANDLER FOR 1062 SET total = -1;\n  SELECT COUNT(*) INTO total FROM customer WHERE c_last = 'it''s' AND c_id = id AND c_balance > 1.5 LIMIT 1;\nEND")
+	require.Equal(t, StatusProblematic, result.Status)
+	require.Equal(t, []string{"body of procedure `test.proc_bob` is not analyzed, literals are masked with their own types"}, result.Errors)
+	require.Contains(t, result.Masked, "PROCEDURE `proc_bob`(IN id INT, name VARCHAR(20), OUT total DECIMAL(10, 2))")
+	require.Contains(t, result.Masked, "HANDLER FOR 1062 SET total = -'bigint(20) 1';")
+	require.Contains(t, result.Masked, "c_last = 'var_string(5) it''s' AND c_id = id AND c_balance > 'decimal(4,1) 1.5' LIMIT 1;")
+
+	result = w.MaskOneResult("CREATE TRIGGER trig_bob BEFORE INSERT ON customer FOR EACH ROW SET NEW.c_last = 'bob'")
+	require.Equal(t, StatusProblematic, result.Status)
+	require.Contains(t, result.Masked, "SET NEW.c_last = 'var_string(5) bob'")
+
+	// arguments are masked with types of parameters
+	result = w.MaskOneResult("CALL proc_bob(42, 'bob', @total)")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "CALL `proc_bob`('int(11) 42', 'varchar(20) bob', @`total`)", result.Masked)
+
+	result = w.MaskOneResult("CALL proc_bob(1)")
+	require.Equal(t, StatusFailed, result.Status)
+
+	result = w.MaskOneResult("DROP PROCEDURE IF EXISTS proc_bob")
+	require.Equal(t, StatusSuccess, result.Status)
+	result = w.MaskOneResult("CALL proc_bob(42, 'bob', @total)")
+	require.Equal(t, StatusFailed, result.Status)
+	require.Equal(t, []string{"procedure `test.proc_bob` not found, it should be created before"}, result.Errors)
+}
+
+func TestMaskOneExecuteCall(t *testing.T) {
+	t.Parallel()
+
+	w := NewEventWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, nil)
+
+	_, _, err := w.maskOneQuery("CREATE PROCEDURE proc_ev (a INT, b DATE) BEGIN END")
+	require.NotNil(t, err)
+	_, err = w.PrepareOne(1, "CALL proc_ev(?, ?)")
+	require.Nil(t, err)
+	params, err := w.MaskOneExecute(1, []interface{}{int64(7), "2020-01-01"})
+	require.Nil(t, err)
+	require.Equal(t, []interface{}{"int(11) 7", "date 2020-01-01"}, params)
+}
+
+func TestMaskStoredProgramNames(t *testing.T) {
+	t.Parallel()
+
+	nameMap := NewGlobalNameMap(map[string]string{
+		"test.customer.c_id":   "db0.table0.col0",
+		"test.customer.c_last": "db0.table0.col1",
+	})
+	nameMap.Routines["test.proc_bob"] = "db0.proc0"
+	nameMap.Variables["last"] = "var0"
+	w := NewSQLWorker(newTestDB(t), MaskFuncMap["debug"], nil, false, nameMap)
+
+	result := w.MaskOneResult("CREATE PROCEDURE proc_bob(IN id INT)\nBEGIN\n  SELECT c.c_last, @last := customer.c_last FROM test.customer AS c WHERE c.c_id = id;\nEND")
+	require.Equal(t, StatusProblematic, result.Status)
+	require.Contains(t, result.Masked, "PROCEDURE `proc0`(IN id INT)")
+	require.Contains(t, result.Masked, "SELECT c.`col1`, @`var0` := `table0`.`col1` FROM `db0`.`table0` AS c WHERE c.`col0` = id;")
+	require.Empty(t, w.Stats.Unmapped)
+
+	result = w.MaskOneResult("CREATE TRIGGER trig_bob BEFORE INSERT ON customer FOR EACH ROW SET NEW.c_last = UPPER(NEW.c_last)")
+	require.Equal(t, StatusProblematic, result.Status)
+	require.Contains(t, result.Masked, "ON `table0` FOR EACH ROW SET NEW.`col1` = UPPER(NEW.`col1`)")
+
+	result = w.MaskOneResult("CALL proc_bob(42)")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "CALL `proc0`('int(11) 42')", result.Masked)
+
+	// names not found fail statements in strict mode
+	nameMap.SetStrict(true)
+	result = w.MaskOneResult("CREATE TRIGGER trig_bob BEFORE INSERT ON customer FOR EACH ROW SET NEW.c_first = 'bob'")
+	require.Equal(t, StatusFailed, result.Status)
+	require.Equal(t, []string{"identifiers not found in name map: routine test.trig_bob, column test.customer.c_first"}, result.Errors)
+}
@@ -1,28 +1,104 @@
 package mask
 
-import "github.com/BugenZhao/sql-masker/tidb"
+import (
+	"strings"
+
+	"github.com/BugenZhao/sql-masker/tidb"
+)
 
 type SQLWorker struct {
 	worker
+	// whether to keep original values of constants in `SQLResult`, which are never masked
+	ShowValues bool
 }
 
-func NewSQLWorker(db *tidb.Context, maskFunc MaskFunc, ignoreIntPK bool, nameMap *NameMap) *SQLWorker {
+func NewSQLWorker(db *tidb.Context, maskFunc MaskFunc, policy *MaskPolicy, ignoreIntPK bool, nameMap *NameMap) *SQLWorker {
 	return &SQLWorker{
-		worker: *newWorker(db, maskFunc, ignoreIntPK, nameMap),
+		worker: *newWorker(db, maskFunc, policy, ignoreIntPK, nameMap),
 	}
 }
 
 func (w *SQLWorker) MaskOne(sql string) (string, error) {
+	newSQL, _, err := w.maskOne(sql)
+	return newSQL, err
+}
+
+func (w *SQLWorker) maskOne(sql string) (string, []InferredConstant, error) {
 	w.Stats.All += 1
 
-	newSQL, err := w.maskOneQuery(sql)
+	newSQL, constants, err := w.maskOneQuery(sql)
 	if err != nil {
 		if newSQL != "" { // problematic
 			w.Stats.Problematic += 1
 		}
-		return newSQL, err
+		return newSQL, constants, err
 	}
 
 	w.Stats.Success += 1
-	return newSQL, nil
+	return newSQL, constants, nil
+}
+
+type Status string
+
+const (
+	StatusSuccess     Status = "success"
+	StatusProblematic Status = "problematic"
+	StatusFailed      Status = "failed"
+)
+
+// A constant in `SQLResult`
+type ConstantResult struct {
+	// the original value, only if `ShowValues`
+	Value   string   `json:"value,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	Columns []string `json:"columns,omitempty"`
+	// Candidate types if the type is ambiguous
+	Candidates []string `json:"candidates,omitempty"`
+}
+
+// Machine-readable result of masking one statement
+type SQLResult struct {
+	Original  string           `json:"original"`
+	Masked    string           `json:"masked,omitempty"`
+	Status    Status           `json:"status"`
+	Errors    []string         `json:"errors,omitempty"`
+	Constants []ConstantResult `json:"constants,omitempty"`
+}
+
+// Like `MaskOne`, but returns a `SQLResult` with status and inferred types of constants
+func (w *SQLWorker) MaskOneResult(sql string) *SQLResult {
+	newSQL, constants, err := w.maskOne(sql)
+
+	result := &SQLResult{
+		Original: sql,
+		Masked:   newSQL,
+		Status:   StatusSuccess,
+		Errors:   ErrorMessages(err),
+	}
+	if err != nil {
+		if newSQL != "" {
+			result.Status = StatusProblematic
+		} else {
+			result.Status = StatusFailed
+		}
+	}
+
+	for _, c := range constants {
+		cr := ConstantResult{}
+		if w.ShowValues {
+			value, err := c.Value.ToString()
+			if err != nil {
+				value = c.Value.String()
+			}
+			cr.Value = value
+		}
+		if c.Type != nil {
+			cr.Type = strings.Split(c.Type.Ft.String(), " ")[0]
+			cr.Columns = c.Type.SourceColumnNames()
+			cr.Candidates = c.Type.CandidateNames()
+		}
+		result.Constants = append(result.Constants, cr)
+	}
+
+	return result
 }
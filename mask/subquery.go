@@ -0,0 +1,203 @@
+package mask
+
+import (
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/expression"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// Uncorrelated scalar subqueries and `EXISTS` subqueries are evaluated during planning, so
+// constants in them never appear in the plan, and the statement may even be planned as `Dual`
+// with the results on empty tables.
+//
+// `subqueryVisitor` infers such subqueries by compiling them alone, and replaces them with
+// `MarkerGroup`s without markers, so that the outer statement is planned as usual and the
+// subqueries are restored as a whole. Correlated subqueries fail to compile alone, and are
+// left as is since they are planned as joins or `Apply`.
+type subqueryVisitor struct {
+	w             *worker
+	replaced      *ReplaceVisitor
+	inferredTypes TypeMap
+	// subqueries which are not scalar, like those in `IN`, `ANY`, `EXISTS` or CTEs
+	nonScalar map[*ast.SubqueryExpr]bool
+	// `WITH` clauses of outer statements, which subqueries may refer to
+	scopes []*ast.WithClause
+	// markers in fields of `EXISTS` subqueries, which are never used
+	unused []ReplaceMarker
+	// columns used in plans of subqueries
+	columns []*expression.Column
+}
+
+func newSubqueryVisitor(w *worker, replaced *ReplaceVisitor, inferredTypes TypeMap) *subqueryVisitor {
+	return &subqueryVisitor{
+		w:             w,
+		replaced:      replaced,
+		inferredTypes: inferredTypes,
+		nonScalar:     make(map[*ast.SubqueryExpr]bool),
+	}
+}
+
+func withClauseOf(in ast.Node) *ast.WithClause {
+	switch in := in.(type) {
+	case *ast.SelectStmt:
+		return in.With
+	case *ast.SetOprStmt:
+		return in.With
+	case *ast.UpdateStmt:
+		return in.With
+	case *ast.DeleteStmt:
+		return in.With
+	}
+	return nil
+}
+
+func (v *subqueryVisitor) Enter(in ast.Node) (ast.Node, bool) {
+	if with := withClauseOf(in); with != nil {
+		v.scopes = append(v.scopes, with)
+	}
+
+	switch in := in.(type) {
+	case *ast.WithClause:
+		for _, cte := range in.CTEs {
+			v.nonScalar[cte.Query] = true
+		}
+	case *ast.ExistsSubqueryExpr:
+		if sub, ok := in.Sel.(*ast.SubqueryExpr); ok {
+			v.nonScalar[sub] = true
+			if sel, ok := sub.Query.(*ast.SelectStmt); ok && sel.Fields != nil {
+				c := &markerCollector{groups: v.replaced.Groups}
+				sel.Fields.Accept(c)
+				v.unused = append(v.unused, c.markers...)
+			}
+		}
+	case *ast.CompareSubqueryExpr:
+		if sub, ok := in.R.(*ast.SubqueryExpr); ok {
+			v.nonScalar[sub] = true
+		}
+	case *ast.PatternInExpr:
+		if sub, ok := in.Sel.(*ast.SubqueryExpr); ok {
+			v.nonScalar[sub] = true
+		}
+	}
+	return in, false
+}
+
+func (v *subqueryVisitor) Leave(in ast.Node) (ast.Node, bool) {
+	if with := withClauseOf(in); with != nil {
+		v.scopes = v.scopes[:len(v.scopes)-1]
+	}
+
+	switch in := in.(type) {
+	case *ast.SubqueryExpr:
+		// a row of several columns can not be replaced with a single marker
+		if !v.nonScalar[in] && v.inferAlone(in) && isSingleColumn(in.Query) {
+			return v.group(in), true
+		}
+	case *ast.ExistsSubqueryExpr:
+		if sub, ok := in.Sel.(*ast.SubqueryExpr); ok && v.inferAlone(sub) {
+			return v.group(in), true
+		}
+	}
+	return in, true
+}
+
+// Infer types of constants in `sub` by compiling it alone, returns false if failed
+func (v *subqueryVisitor) inferAlone(sub *ast.SubqueryExpr) bool {
+	// compile a copy, since the AST is changed by compiling even if it fails
+	sql, err := v.w.db.RestoreSQL(sub.Query)
+	if err != nil {
+		return false
+	}
+	if with := v.outerWithClause(); with != nil && withClauseOf(sub.Query) == nil {
+		prefix, err := v.w.db.RestoreSQL(with)
+		if err != nil {
+			return false
+		}
+		sql = prefix + " " + sql
+	}
+	stmt, err := v.w.db.ParseOne(sql)
+	if err != nil {
+		return false
+	}
+	columns, err := v.w.inferStmt(stmt, v.inferredTypes)
+	if err != nil {
+		return false
+	}
+	v.columns = append(v.columns, columns...)
+	return true
+}
+
+// Merge `WITH` clauses of outer statements, so that CTEs are visible to the subquery compiled
+// alone, like `(SELECT MAX(total) FROM cte)`
+func (v *subqueryVisitor) outerWithClause() *ast.WithClause {
+	if len(v.scopes) == 0 {
+		return nil
+	}
+	merged := &ast.WithClause{}
+	for _, with := range v.scopes {
+		merged.IsRecursive = merged.IsRecursive || with.IsRecursive
+		merged.CTEs = append(merged.CTEs, with.CTEs...)
+	}
+	return merged
+}
+
+func (v *subqueryVisitor) group(in ast.ExprNode) ast.ExprNode {
+	n := v.replaced.nextMarker()
+	v.replaced.Groups[n] = &MarkerGroup{Expr: in}
+	return ast.NewValueExpr(n.IntValue(), "", "")
+}
+
+// Constants in fields of `EXISTS` subqueries are not in the plan if the subqueries are
+// correlated, like `EXISTS (SELECT 1 FROM ...)`, so use their own types
+func (v *subqueryVisitor) inferUnused() {
+	for _, m := range v.unused {
+		if _, ok := v.inferredTypes[m]; ok {
+			continue
+		}
+		if expr, ok := v.replaced.OriginExprs[m]; ok {
+			v.inferredTypes[m] = NewInferredType(&expr.Type)
+		}
+	}
+}
+
+func isSingleColumn(query ast.ResultSetNode) bool {
+	switch query := query.(type) {
+	case *ast.SelectStmt:
+		fields := query.Fields.Fields
+		return len(fields) == 1 && fields[0].WildCard == nil
+	case *ast.SetOprStmt:
+		if query.SelectList != nil && len(query.SelectList.Selects) > 0 {
+			if sel, ok := query.SelectList.Selects[0].(*ast.SelectStmt); ok {
+				return isSingleColumn(sel)
+			}
+		}
+	}
+	return false
+}
+
+// Collect markers in a REPLACED subtree, markers in groups are expanded
+type markerCollector struct {
+	groups     GroupMap
+	markers    []ReplaceMarker
+	structural structuralTracker
+}
+
+func (c *markerCollector) Enter(in ast.Node) (ast.Node, bool) {
+	c.structural.enter(in)
+	return in, false
+}
+
+func (c *markerCollector) Leave(in ast.Node) (ast.Node, bool) {
+	structural := c.structural.inside()
+	c.structural.leave(in)
+
+	if expr, ok := in.(*driver.ValueExpr); ok && !structural {
+		m := ReplaceMarker(expr.Datum.GetInt64())
+		if group, ok := c.groups[m]; ok {
+			c.markers = append(c.markers, group.Markers...)
+		} else if m.valid() {
+			c.markers = append(c.markers, m)
+		}
+	}
+	return in, true
+}
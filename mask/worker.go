@@ -1,25 +1,44 @@
 package mask
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"sort"
-	"strconv"
+	"strings"
 
 	"github.com/BugenZhao/sql-masker/tidb"
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/terror"
+	"github.com/pingcap/tidb/expression"
 	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
 )
 
 type Stats struct {
 	All         uint64
 	Problematic uint64
 	Success     uint64
+	// appearances of identifiers not found in the name map, like `column t.unknown`
+	Unmapped map[string]uint64
 }
 
 func (s *Stats) Merge(other Stats) {
 	s.All += other.All
 	s.Problematic += other.Problematic
 	s.Success += other.Success
+	for name, count := range other.Unmapped {
+		s.addUnmapped(name, count)
+	}
+}
+
+func (s *Stats) addUnmapped(name string, count uint64) {
+	if s.Unmapped == nil {
+		s.Unmapped = map[string]uint64{}
+	}
+	s.Unmapped[name] += count
 }
 
 func (s Stats) String() string {
@@ -42,36 +61,108 @@ Total        %d
 		s.Success, s.Problematic, s.Failed(), s.All)
 }
 
+// Print identifiers not found in the name map to `out` with their appearances, the most frequent
+// first, so that the map can be completed
+func (s Stats) PrintUnmapped(out io.Writer) {
+	if len(s.Unmapped) == 0 {
+		return
+	}
+	names := make([]string, 0, len(s.Unmapped))
+	for name := range s.Unmapped {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if s.Unmapped[names[i]] == s.Unmapped[names[j]] {
+			return names[i] < names[j]
+		}
+		return s.Unmapped[names[i]] > s.Unmapped[names[j]]
+	})
+
+	fmt.Fprintf(out, "\n====Unmapped Names====\n")
+	for _, name := range names {
+		fmt.Fprintf(out, "%-12d %s\n", s.Unmapped[name], name)
+	}
+}
+
 type worker struct {
+	valueMasker
 	Stats         Stats
 	db            *tidb.Context
-	maskFunc      MaskFunc
 	globalNameMap *NameMap
-	ignoreIntPK   bool
+	// types of parameters of procedures by `db.name` in lower case, learned from `CREATE PROCEDURE`
+	procedures map[string][]*types.FieldType
 }
 
-func newWorker(db *tidb.Context, maskFunc MaskFunc, ignoreIntPK bool, globalNameMap *NameMap) *worker {
+func newWorker(db *tidb.Context, maskFunc MaskFunc, policy *MaskPolicy, ignoreIntPK bool, globalNameMap *NameMap) *worker {
 	return &worker{
+		valueMasker:   newValueMasker(maskFunc, policy, ignoreIntPK),
 		db:            db,
-		maskFunc:      maskFunc,
 		globalNameMap: globalNameMap,
-		ignoreIntPK:   ignoreIntPK,
+		procedures:    make(map[string][]*types.FieldType),
+	}
+}
+
+// Parser errors quote the text near the error, which may contain values, so they're replaced
+// with this one to keep values out of masked outputs
+var errParse = errors.New("failed to parse statement")
+
+func (w *worker) parse(sql string) ([]ast.StmtNode, error) {
+	nodes, err := w.db.Parse(sql)
+	if err != nil {
+		return nil, errParse
+	}
+	return nodes, nil
+}
+
+func (w *worker) parseOne(sql string) (ast.StmtNode, error) {
+	node, err := w.db.ParseOne(sql)
+	if err != nil {
+		return nil, errParse
 	}
+	return node, nil
 }
 
-// Replace with `Value` mode
-func (w *worker) replaceValue(node ast.StmtNode) (ast.StmtNode, ExprMap, error) {
+// Errors of executing statements may quote values like `Variable 'x' can't be set to the value
+// of 'y'`, so only the kind of the statement and the error code are reported
+func executeError(node ast.StmtNode, err error) error {
+	for {
+		c, ok := err.(interface{ Cause() error })
+		if !ok || c.Cause() == nil {
+			break
+		}
+		err = c.Cause()
+	}
+
+	kind := stmtKind(node)
+	switch err := err.(type) {
+	case *terror.Error:
+		return fmt.Errorf("failed to execute `%s` statement; error %d", kind, err.Code())
+	case *mysql.SQLError:
+		return fmt.Errorf("failed to execute `%s` statement; error %d", kind, err.Code)
+	default:
+		return fmt.Errorf("failed to execute `%s` statement", kind)
+	}
+}
+
+// Kind of a statement like `CreateTable`, which is reported in errors instead of its text
+func stmtKind(node ast.Node) string {
+	kind := fmt.Sprintf("%T", node)
+	return strings.TrimSuffix(kind[strings.LastIndex(kind, ".")+1:], "Stmt")
+}
+
+// Replace with `Value` mode, returns the visitor with records of replacing
+func (w *worker) replaceValue(node ast.StmtNode) (ast.StmtNode, *ReplaceVisitor, error) {
 	v := NewReplaceVisitor(ReplaceModeValue)
 	newNode, _ := node.Accept(v)
 
-	return newNode.(ast.StmtNode), v.OriginExprs, nil
+	return newNode.(ast.StmtNode), v, nil
 }
 
 // Replace with `ParamMarker` mode, for `PREPARE` statements
-func (w *worker) replaceParamMarker(sql string) (ast.StmtNode, []ReplaceMarker, error) {
-	node, err := w.db.ParseOne(sql)
+func (w *worker) replaceParamMarker(sql string) (ast.StmtNode, []ReplaceMarker, *ReplaceVisitor, error) {
+	node, err := w.parseOne(sql)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	v := NewReplaceVisitor(ReplaceModeParamMarker)
 	newNode, _ := node.Accept(v)
@@ -82,15 +173,15 @@ func (w *worker) replaceParamMarker(sql string) (ast.StmtNode, []ReplaceMarker,
 	}
 	sort.Slice(markers, func(i, j int) bool { return v.Offsets[markers[i]] < v.Offsets[markers[j]] })
 
-	return newNode.(ast.StmtNode), markers, nil
+	return newNode.(ast.StmtNode), markers, v, nil
 }
 
 // Restore markers in replaced AST, then restore into SQL
-func (w *worker) restore(stmtNode ast.StmtNode, originExprs ExprMap, inferredTypes TypeMap, nameMap *NameMap) (string, error) {
-	v := NewRestoreVisitor(originExprs, inferredTypes, w.maskFunc, nameMap, w.ignoreIntPK)
+func (w *worker) restore(stmtNode ast.StmtNode, replaced *ReplaceVisitor, inferredTypes TypeMap, nameMap *NameMap) (string, error) {
+	v := NewRestoreVisitor(replaced.OriginExprs, replaced.Groups, inferredTypes, w.maskFunc, w.policy, nameMap, w.ignoreIntPK)
 	newNode, ok := stmtNode.Accept(v)
-	if !ok || (v.success == 0 && len(originExprs) > 0) {
-		return "", v.err
+	if !ok || (v.success == 0 && len(replaced.OriginExprs) > 0) {
+		return "", v.Err()
 	}
 
 	newSQL, err := w.db.RestoreSQL(newNode)
@@ -98,40 +189,60 @@ func (w *worker) restore(stmtNode ast.StmtNode, originExprs ExprMap, inferredTyp
 		return "", err
 	}
 
-	return newSQL, v.err
+	return newSQL, v.Err()
 }
 
-// Infer types of all constants in a REPLACED AST, returns several maps
-func (w *worker) infer(stmtNode ast.StmtNode) (TypeMap, *NameMap, error) {
-	execStmt, err := w.db.CompileStmtNode(stmtNode)
+// Infer types of all constants in a REPLACED AST, returns several maps. Subqueries evaluated
+// during planning are replaced with `MarkerGroup`s in place, see `subqueryVisitor`.
+func (w *worker) infer(stmtNode ast.StmtNode, replaced *ReplaceVisitor) (TypeMap, *NameMap, error) {
+	if call, ok := stmtNode.(*ast.CallStmt); ok {
+		return w.inferCall(call, replaced)
+	}
+
+	inferredTypes := make(TypeMap)
+	sv := newSubqueryVisitor(w, replaced, inferredTypes)
+	newNode, _ := stmtNode.Accept(sv)
+
+	columns, err := w.inferStmt(newNode.(ast.StmtNode), inferredTypes)
 	if err != nil {
 		return nil, nil, err
 	}
+	columns = append(columns, sv.columns...)
+	sv.inferUnused()
+	expandGroupTypes(replaced.Groups, inferredTypes)
 
-	b := NewCastGraphBuilder()
-	err = b.Build(execStmt.Plan)
+	localNameMap, err := NewLocalNameMap(w.globalNameMap, columns, w.db.CurrentDB())
 	if err != nil {
 		return nil, nil, err
 	}
+	return inferredTypes, localNameMap, nil
+}
 
-	localNameMap, err := NewLocalNameMap(w.globalNameMap, b.Columns, w.db.CurrentDB())
+// Compile a REPLACED statement and infer types of constants in its plan into `inferredTypes`,
+// returns columns used in the plan
+func (w *worker) inferStmt(stmtNode ast.StmtNode, inferredTypes TypeMap) ([]*expression.Column, error) {
+	execStmt, err := w.db.CompileStmtNode(stmtNode)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	inferredTypes := make(TypeMap)
-	for _, c := range b.Constants {
-		tp := b.Graph.InferType(c)
+	b := NewCastGraphBuilder()
+	err = b.Build(execStmt.Plan)
+	if err != nil {
+		return nil, err
+	}
 
-		s, err := c.Value.ToString()
-		if err != nil {
+	for _, c := range b.Constants {
+		m, ok := markerOf(c.Value)
+		if !ok {
 			continue
 		}
-		f, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			continue
+		tp := b.Graph.InferType(c)
+		if prev, ok := inferredTypes[m]; ok {
+			// the same constant may appear several times in the plan, like pushed-down conditions
+			tp = mergeTypes(prev, tp)
 		}
-		inferredTypes[ReplaceMarker(f)] = tp
+		inferredTypes[m] = tp
 	}
 	for _, h := range b.Handles {
 		switch h := h.(type) {
@@ -141,13 +252,72 @@ func (w *worker) infer(stmtNode ast.StmtNode) (TypeMap, *NameMap, error) {
 			// ignore common handle for clustered index, since we disabled this feature
 		}
 	}
+	return b.Columns, nil
+}
 
-	return inferredTypes, localNameMap, nil
+func mergeColumns(a []*expression.Column, b []*expression.Column) []*expression.Column {
+	merged := append([]*expression.Column{}, a...)
+	for _, col := range b {
+		found := false
+		for _, c := range merged {
+			if c.OrigName == col.OrigName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, col)
+		}
+	}
+	return merged
+}
+
+// A constant in a statement, with its inferred type or nil if not inferred
+type InferredConstant struct {
+	Value types.Datum
+	Type  *InferredType
+}
+
+// Infer types and source columns of all constants in `sql` without masking,
+// in the order they appear
+func (w *worker) InferConstants(sql string) ([]InferredConstant, error) {
+	node, err := w.parseOne(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	replacedStmtNode, replaced, err := w.replaceValue(node)
+	if err != nil {
+		return nil, err
+	}
+	inferredTypes, _, err := w.infer(replacedStmtNode, replaced)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedInferredConstants(replaced.OriginExprs, inferredTypes), nil
+}
+
+func sortedInferredConstants(originExprs ExprMap, inferredTypes TypeMap) []InferredConstant {
+	markers := make([]ReplaceMarker, 0, len(originExprs))
+	for m := range originExprs {
+		markers = append(markers, m)
+	}
+	sort.Slice(markers, func(i, j int) bool { return markers[i] < markers[j] })
+
+	constants := make([]InferredConstant, 0, len(markers))
+	for _, m := range markers {
+		constants = append(constants, InferredConstant{
+			Value: originExprs[m].Datum,
+			Type:  inferredTypes[m],
+		})
+	}
+	return constants
 }
 
 func (w *worker) mayExecute(node ast.StmtNode) (bool, error) {
 	switch node := node.(type) {
-	case *ast.SetStmt, ast.DDLNode:
+	case *ast.SetStmt, *ast.UseStmt:
 		_, err := w.db.ExecuteOneStmt(node)
 		return true, err
 
@@ -156,35 +326,200 @@ func (w *worker) mayExecute(node ast.StmtNode) (bool, error) {
 	}
 }
 
-func (w *worker) maskOneQuery(sql string) (string, error) {
-	node, err := w.db.ParseOne(sql)
+// Mask one query, returns the masked sql and all constants in it with inferred types.
+//
+// The query may consist of several statements, like `COM_QUERY` with `CLIENT_MULTI_STATEMENTS`,
+// which are masked one by one with the shared session and joined again. The query fails if any
+// statement fails, and is problematic if any statement is problematic.
+func (w *worker) maskOneQuery(sql string) (string, []InferredConstant, error) {
+	if p, ok := parseStoredProgram(sql, w.db.CurrentDB()); ok {
+		return w.maskStoredProgram(sql, p)
+	}
+
+	nodes, err := w.parse(sql)
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+	if len(nodes) == 0 {
+		return "", nil, fmt.Errorf("no stmt found")
+	}
+	if len(nodes) == 1 {
+		return w.maskOneStmt(sql, nodes[0])
+	}
+
+	newSQLs := make([]string, 0, len(nodes))
+	allConstants := []InferredConstant{}
+	var errs MultiError
+	for i, node := range nodes {
+		// texts of statements except the last one end with the delimiter
+		text := strings.TrimSuffix(strings.TrimSpace(node.Text()), ";")
+		newSQL, constants, err := w.maskOneStmt(text, node)
+		if err != nil && newSQL == "" {
+			return "", nil, fmt.Errorf("failed to mask stmt %d of %d; %w", i+1, len(nodes), err)
+		}
+		if multi, ok := err.(MultiError); ok {
+			errs = append(errs, multi...)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		newSQLs = append(newSQLs, newSQL)
+		allConstants = append(allConstants, constants...)
+	}
+
+	newSQL := strings.Join(newSQLs, "; ")
+	if len(errs) > 0 {
+		return newSQL, allConstants, errs
+	}
+	return newSQL, allConstants, nil
+}
+
+// Record identifiers not found in the local `nameMap` of a statement, which fails the statement
+// in strict mode
+func (w *worker) checkUnmapped(nameMap *NameMap) error {
+	if nameMap == nil || len(nameMap.unmapped) == 0 {
+		return nil
+	}
+	for _, name := range nameMap.unmapped {
+		w.Stats.addUnmapped(name, 1)
+	}
+	if w.globalNameMap.strict {
+		return fmt.Errorf("identifiers not found in name map: %s", strings.Join(nameMap.unmapped, ", "))
+	}
+	return nil
+}
+
+// Whether `assignment` is to a user variable, rather than a system variable or `SET NAMES`
+func isUserVariable(assignment *ast.VariableAssignment) bool {
+	return !assignment.IsSystem && assignment.Name != ast.SetNames && assignment.Name != ast.SetCharset
+}
+
+// Masks literals in place with their own types, for values without columns to infer types from.
+// Literals failed to mask are replaced with `NULL`.
+type literalMasker struct {
+	masker    *valueMasker
+	constants []InferredConstant
+	errs      MultiError
+}
+
+func (v *literalMasker) Enter(in ast.Node) (ast.Node, bool) {
+	return in, false
+}
+
+func (v *literalMasker) Leave(in ast.Node) (ast.Node, bool) {
+	expr, ok := in.(*driver.ValueExpr)
+	if !ok || expr.Datum.IsNull() {
+		return in, true
+	}
+	tp := ownInferredType(expr.Datum)
+	v.constants = append(v.constants, InferredConstant{Value: expr.Datum, Type: tp})
+	maskedDatum, maskedType, masked, err := v.masker.mask(expr.Datum, tp)
+	if err != nil {
+		v.errs = append(v.errs, fmt.Errorf("constant %d: %w", len(v.constants), err))
+		return ast.NewValueExpr(nil, "", ""), true
+	}
+	if !masked {
+		return in, true
+	}
+	maskedExpr := ast.NewValueExpr(maskedDatum.GetValue(), "", "")
+	maskedExpr.SetType(maskedType)
+	return maskedExpr, true
+}
+
+// Mask values assigned to user variables in an executed `SET` statement with their own types,
+// since user variables have no types to infer from, and map names of them if a name map is given.
+// Statements without user variables are returned as is.
+func (w *worker) maskSet(sql string, set *ast.SetStmt) (string, []InferredConstant, error) {
+	v := &literalMasker{masker: &w.valueMasker}
+	hasUserVariable := false
+	for _, assignment := range set.Variables {
+		if !isUserVariable(assignment) || assignment.Value == nil {
+			continue
+		}
+		hasUserVariable = true
+		newValue, _ := assignment.Value.Accept(v)
+		assignment.Value = newValue.(ast.ExprNode)
+	}
+	if !hasUserVariable {
+		return sql, nil, nil
+	}
+
+	var newNode ast.Node = set
+	if w.globalNameMap != nil {
+		localNameMap, err := NewLocalNameMap(w.globalNameMap, nil, w.db.CurrentDB())
+		if err != nil {
+			return "", nil, err
+		}
+		newNode, _ = set.Accept(NewNameOnlyRestoreVisitor(localNameMap))
+		if err := w.checkUnmapped(localNameMap); err != nil {
+			return "", nil, err
+		}
+	}
+	newSQL, err := w.db.RestoreSQL(newNode)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(v.errs) > 0 {
+		return fmt.Sprintf("/* PROBLEMATIC: %v */ %s", v.errs, newSQL), v.constants, v.errs
+	}
+	return newSQL, v.constants, nil
+}
+
+// Map the database name of an executed `USE` statement if a name map is given
+func (w *worker) mapUse(sql string, use *ast.UseStmt) (string, []InferredConstant, error) {
+	if w.globalNameMap == nil {
+		return sql, nil, nil
+	}
+	localNameMap, err := NewLocalNameMap(w.globalNameMap, nil, w.db.CurrentDB())
+	if err != nil {
+		return "", nil, err
+	}
+	use.DBName = localNameMap.DB(use.DBName)
+	if err := w.checkUnmapped(localNameMap); err != nil {
+		return "", nil, err
+	}
+	newSQL, err := w.db.RestoreSQL(use)
+	if err != nil {
+		return "", nil, err
+	}
+	return newSQL, nil, nil
+}
+
+// Mask one statement `node` parsed from `sql`, like `maskOneQuery`
+func (w *worker) maskOneStmt(sql string, node ast.StmtNode) (string, []InferredConstant, error) {
+	if ddl, ok := node.(ast.DDLNode); ok {
+		return w.maskDDL(ddl)
 	}
 
 	executed, err := w.mayExecute(node) // todo: add a flag
 	if executed {
 		if err != nil {
-			return "", fmt.Errorf("error when trying to execute `%s`; %w", sql, err)
+			return "", nil, executeError(node, err)
+		} else if use, ok := node.(*ast.UseStmt); ok {
+			return w.mapUse(sql, use)
 		} else {
-			return sql, nil
+			return w.maskSet(sql, node.(*ast.SetStmt))
 		}
 	}
 
-	replacedStmtNode, originExprs, err := w.replaceValue(node)
+	replacedStmtNode, replaced, err := w.replaceValue(node)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	inferredTypes, localNameMap, err := w.infer(replacedStmtNode)
+	inferredTypes, localNameMap, err := w.infer(replacedStmtNode, replaced)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
+	constants := sortedInferredConstants(replaced.OriginExprs, inferredTypes)
 
-	newSQL, err := w.restore(replacedStmtNode, originExprs, inferredTypes, localNameMap)
+	newSQL, err := w.restore(replacedStmtNode, replaced, inferredTypes, localNameMap)
+	if unmappedErr := w.checkUnmapped(localNameMap); unmappedErr != nil {
+		return "", constants, unmappedErr
+	}
 	if err != nil && newSQL != "" { // problematic
 		newSQL = fmt.Sprintf("/* PROBLEMATIC: %v */ %s", err, newSQL)
 	}
 
-	return newSQL, err
+	return newSQL, constants, err
 }
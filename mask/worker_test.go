@@ -0,0 +1,365 @@
+package mask
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BugenZhao/sql-masker/tidb"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testInstance     *tidb.Instance
+	testInstanceErr  error
+	testInstanceOnce sync.Once
+)
+
+var testSchema = []string{
+	"CREATE TABLE customer (c_id INT, c_d_id INT, c_last VARCHAR(16), c_balance DECIMAL(12, 2), c_since DATETIME, c_data JSON, c_state CHAR(2))",
+	"CREATE TABLE orders (o_id INT PRIMARY KEY, o_c_id INT, o_entry_d DATETIME, o_carrier_id INT, INDEX idx_c_id (o_c_id))",
+	"CREATE TABLE stock (s_i_id INT, s_w_id INT, s_quantity INT, s_data VARCHAR(50), PRIMARY KEY (s_w_id, s_i_id), UNIQUE KEY uk_data (s_data))",
+	"CREATE TABLE item (i_id INT PRIMARY KEY, i_name VARCHAR(24) DEFAULT 'none', i_price DECIMAL(5, 2), i_tax DECIMAL(5, 2) AS (i_price * 0.1), i_data VARCHAR(50))",
+}
+
+// Open a context on a shared instance with `testSchema` in database `test`
+func newTestDB(t *testing.T) *tidb.Context {
+	testInstanceOnce.Do(func() {
+		testInstance, testInstanceErr = tidb.NewInstance()
+		if testInstanceErr != nil {
+			return
+		}
+		db, err := testInstance.OpenContext()
+		if err != nil {
+			testInstanceErr = err
+			return
+		}
+		_ = db.MayCreateDB("test")
+		_ = db.UseDB("test")
+		for _, sql := range testSchema {
+			if err := db.Execute(sql); err != nil {
+				testInstanceErr = err
+				return
+			}
+		}
+	})
+	require.Nil(t, testInstanceErr)
+
+	db, err := testInstance.OpenContext()
+	require.Nil(t, err)
+	require.Nil(t, db.UseDB("test"))
+	return db
+}
+
+func TestInferConstants(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["identical"], nil, false, nil)
+
+	constants, err := w.InferConstants("SELECT * FROM customer WHERE c_last = 'Alice' AND c_id = 42 AND c_balance > 1.5")
+	require.Nil(t, err)
+	require.Len(t, constants, 3)
+
+	expected := []struct {
+		value  string
+		column string
+	}{
+		{"Alice", "test.customer.c_last"},
+		{"42", "test.customer.c_id"},
+		{"1.5", "test.customer.c_balance"},
+	}
+	for i, c := range constants {
+		value, err := c.Value.ToString()
+		require.Nil(t, err)
+		require.Equal(t, expected[i].value, value)
+		require.NotNil(t, c.Type)
+		require.Equal(t, expected[i].column, c.Type.ColumnName())
+		require.Equal(t, []string{expected[i].column}, c.Type.SourceColumnNames())
+	}
+
+	constants, err = w.InferConstants("UPDATE customer SET c_d_id = 3 WHERE c_id = 42")
+	require.Nil(t, err)
+	require.Len(t, constants, 2)
+	require.Equal(t, "test.customer.c_d_id", constants[0].Type.ColumnName())
+	require.Equal(t, "int(11) against `test.customer.c_d_id`", constants[0].Type.String())
+}
+
+func TestMaskOneResult(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["identical"], nil, false, nil)
+	w.ShowValues = true
+
+	result := w.MaskOneResult("SELECT * FROM customer WHERE c_id = 42")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "SELECT * FROM `test`.`customer` WHERE `c_id`=42", result.Masked)
+	require.Empty(t, result.Errors)
+	require.Equal(t, []ConstantResult{{Value: "42", Type: "int(11)", Columns: []string{"test.customer.c_id"}}}, result.Constants)
+
+	result = w.MaskOneResult("SELECT * FROM no_such_table")
+	require.Equal(t, StatusFailed, result.Status)
+	require.Empty(t, result.Masked)
+	require.Len(t, result.Errors, 1)
+
+	// errors of failed statements never contain values
+	result = w.MaskOneResult("SELECT * FROM customer WHERE WHERE c_last = 'SECRET4'")
+	require.Equal(t, []string{"failed to parse statement"}, result.Errors)
+	result = w.MaskOneResult("SET @@session.sql_mode = 'SECRET5'")
+	require.Equal(t, StatusFailed, result.Status)
+	require.Equal(t, []string{"failed to execute `Set` statement; error 1231"}, result.Errors)
+
+	require.Equal(t, Stats{All: 4, Success: 1}, w.Stats)
+}
+
+func TestMaskAmbiguous(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+
+	// `42` is compared with both an int and a varchar column
+	sql := "SELECT * FROM customer WHERE 42 IN (c_id, c_last)"
+	result := w.MaskOneResult(sql)
+	require.Equal(t, StatusProblematic, result.Status)
+	require.Equal(t, []string{"type for constant 1 is ambiguous among varchar(16) of `test.customer.c_last`, int(11) of `test.customer.c_id`"}, result.Errors)
+	require.Equal(t, []ConstantResult{{
+		Type:       "varchar(16)",
+		Columns:    []string{"test.customer.c_id", "test.customer.c_last"},
+		Candidates: []string{"varchar(16) of `test.customer.c_last`", "int(11) of `test.customer.c_id`"},
+	}}, result.Constants)
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, result, w.MaskOneResult(sql))
+	}
+	require.Equal(t, Stats{All: 11, Problematic: 11}, w.Stats)
+
+	// columns of the same eval type are not ambiguous, the widest one is chosen
+	result = w.MaskOneResult("SELECT * FROM customer WHERE 'a' IN (c_state, c_last)")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "varchar(16)", result.Constants[0].Type)
+	require.Empty(t, result.Constants[0].Candidates)
+
+	// original values are never written along with the masked statement
+	w = NewSQLWorker(db, MaskFuncMap["workload-sim"], nil, false, nil)
+	result = w.MaskOneResult("SELECT * FROM customer WHERE c_state = 'ca' AND 'secret-ssn-123' IN (c_id, c_last)")
+	require.Equal(t, StatusProblematic, result.Status)
+	require.Regexp(t, "^type for constant 2 is ambiguous", result.Errors[0])
+	require.NotContains(t, result.Masked, "secret-ssn-123")
+	require.Empty(t, result.Constants[1].Value)
+	w.ShowValues = true
+	result = w.MaskOneResult("SELECT * FROM customer WHERE c_state = 'ca' AND 'secret-ssn-123' IN (c_id, c_last)")
+	require.Equal(t, "secret-ssn-123", result.Constants[1].Value)
+}
+
+func TestMaskStructuralArgs(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+
+	cases := []struct {
+		sql    string
+		masked string
+	}{
+		{"SELECT * FROM customer WHERE c_since < DATE '2020-01-01' - INTERVAL 1 YEAR", "SELECT * FROM `test`.`customer` WHERE `c_since`<DATE_SUB(DATE 'datetime 2020-01-01 00:00:00', INTERVAL 1 YEAR)"},
+		{"SELECT SUBSTRING(c_last, 1001, 2) FROM customer WHERE c_data->>'$.a' = 'x'", "SELECT SUBSTRING(`c_last`, 1001, 2) FROM `test`.`customer` WHERE JSON_UNQUOTE(JSON_EXTRACT(`c_data`, '$.a'))='var_string(65535) x'"},
+		{"SELECT DATE_FORMAT(c_since, '%Y') FROM customer WHERE ROUND(c_balance, 1) = 2.5", "SELECT DATE_FORMAT(`c_since`, '%Y') FROM `test`.`customer` WHERE ROUND(`c_balance`, 1)='decimal(12,2) 2.50'"},
+	}
+	for _, c := range cases {
+		result := w.MaskOneResult(c.sql)
+		require.Equal(t, StatusSuccess, result.Status, c.sql)
+		require.Equal(t, c.masked, result.Masked)
+	}
+}
+
+func TestMaskPatterns(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["format-preserving"], nil, false, nil)
+
+	// the masked pattern still matches the masked value
+	value := w.MaskOneResult("SELECT * FROM customer WHERE c_last = 'abcdef'")
+	require.Equal(t, "SELECT * FROM `test`.`customer` WHERE `c_last`='xhqcho'", value.Masked)
+
+	cases := []struct {
+		sql    string
+		masked string
+	}{
+		{"SELECT * FROM customer WHERE c_last LIKE 'abc%def_'", "SELECT * FROM `test`.`customer` WHERE `c_last` LIKE 'xhq%oig_'"},
+		{"SELECT * FROM customer WHERE c_last NOT LIKE 'ab|%c%' ESCAPE '|'", "SELECT * FROM `test`.`customer` WHERE `c_last` NOT LIKE 'xh|%x%' ESCAPE '|'"},
+		{"SELECT * FROM customer WHERE c_last REGEXP '^ab[0-9]+c$'", "SELECT * FROM `test`.`customer` WHERE `c_last` REGEXP '^xh[0-9]+d$'"},
+		{"SELECT * FROM customer WHERE c_last RLIKE 'abc.*'", "SELECT * FROM `test`.`customer` WHERE `c_last` REGEXP 'xhq.*'"},
+	}
+	for _, c := range cases {
+		result := w.MaskOneResult(c.sql)
+		require.Equal(t, StatusSuccess, result.Status, c.sql)
+		require.Equal(t, c.masked, result.Masked)
+	}
+
+	// mask functions without pattern support mask patterns as usual
+	debug := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+	result := debug.MaskOneResult("SELECT * FROM customer WHERE c_last LIKE 'abc%'")
+	require.Equal(t, "SELECT * FROM `test`.`customer` WHERE `c_last` LIKE 'varchar(16) abc%'", result.Masked)
+
+	// so do mask functions hashing strings, which keeps patterns consistent with values
+	sim := NewSQLWorker(db, MaskFuncMap["workload-sim"], nil, false, nil)
+	value = sim.MaskOneResult("SELECT * FROM customer WHERE c_last = 'abcdef'")
+	result = sim.MaskOneResult("SELECT * FROM customer WHERE c_last LIKE 'abcdef'")
+	require.Equal(t, strings.Replace(value.Masked, "=", " LIKE ", 1), result.Masked)
+}
+
+func TestMaskSet(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+
+	// values of user variables are masked with their own types, while system variables are kept
+	result := w.MaskOneResult("SET @customer_id = 42, @name = CONCAT('a', 'b'), @@session.sql_mode = 'ANSI_QUOTES'")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "SET @`customer_id`='bigint(20) 42', @`name`=CONCAT('var_string(5) a', 'var_string(5) b'), @@SESSION.`sql_mode`='ANSI_QUOTES'", result.Masked)
+	require.Len(t, result.Constants, 3)
+
+	result = w.MaskOneResult("SET NAMES utf8mb4")
+	require.Equal(t, "SET NAMES utf8mb4", result.Masked)
+}
+
+func TestMaskMultiStatements(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+
+	// statements share the session, so the table created is visible to the following ones
+	result := w.MaskOneResult("CREATE TABLE multi_stmt (a INT);INSERT INTO multi_stmt VALUES (1); SELECT * FROM customer WHERE c_last = 'a'")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "CREATE TABLE `test`.`multi_stmt` (`a` INT(11)); INSERT INTO `test`.`multi_stmt` VALUES ('int(11) 1'); SELECT * FROM `test`.`customer` WHERE `c_last`='varchar(16) a'", result.Masked)
+	require.Len(t, result.Constants, 2)
+
+	result = w.MaskOneResult("SELECT * FROM customer WHERE 42 IN (c_id, c_last); SELECT * FROM customer WHERE c_id = 1")
+	require.Equal(t, StatusProblematic, result.Status)
+	require.Len(t, result.Errors, 1)
+	require.Contains(t, result.Masked, "; SELECT * FROM `test`.`customer` WHERE `c_id`='int(11) 1'")
+
+	result = w.MaskOneResult("SELECT * FROM customer WHERE c_id = 1; SELECT * FROM no_such_table")
+	require.Equal(t, StatusFailed, result.Status)
+	require.Empty(t, result.Masked)
+}
+
+func TestInferFoldedConstants(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["identical"], nil, false, nil)
+
+	cases := []struct {
+		sql     string
+		columns []string
+	}{
+		{"SELECT * FROM orders WHERE o_c_id = -5", []string{"test.orders.o_c_id"}},
+		{"SELECT * FROM orders WHERE o_c_id = (2 * 3) - 1", []string{"test.orders.o_c_id", "test.orders.o_c_id", "test.orders.o_c_id"}},
+		{"SELECT * FROM orders WHERE o_entry_d = CAST('2020-01-01' AS DATETIME)", []string{"test.orders.o_entry_d"}},
+		{"SELECT * FROM customer WHERE c_last = CAST(5 AS CHAR) AND c_balance = -1.5", []string{"test.customer.c_last", "test.customer.c_balance"}},
+	}
+	for _, c := range cases {
+		constants, err := w.InferConstants(c.sql)
+		require.Nil(t, err)
+		require.Len(t, constants, len(c.columns), c.sql)
+		for i, constant := range constants {
+			require.NotNil(t, constant.Type, "constant `%v` in `%s` not inferred", constant.Value, c.sql)
+			require.Equal(t, c.columns[i], constant.Type.ColumnName(), c.sql)
+		}
+	}
+
+	result := w.MaskOneResult("SELECT * FROM orders WHERE o_c_id = 1 + 2")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "SELECT * FROM `test`.`orders` WHERE `o_c_id`=1+2", result.Masked)
+
+	// markers implicitly cast by the planner are lost and masked with their own types, while
+	// explicit casts are folded in groups
+	debug := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nil)
+	result = debug.MaskOneResult("UPDATE orders SET o_entry_d = '2021-01-01' WHERE o_id = 1")
+	require.Equal(t, StatusProblematic, result.Status)
+	require.Equal(t, []string{"type for constant 1 not inferred"}, result.Errors)
+	require.Contains(t, result.Masked, "`o_entry_d`='var_string(5) 2021-01-01'")
+	result = debug.MaskOneResult("SELECT * FROM orders WHERE o_entry_d = CAST('2021-01-01' AS DATETIME)")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "SELECT * FROM `test`.`orders` WHERE `o_entry_d`=CAST('datetime 2021-01-01 00:00:00' AS DATETIME)", result.Masked)
+}
+
+func TestInferSubqueries(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	w := NewSQLWorker(db, MaskFuncMap["identical"], nil, false, nil)
+
+	cases := []struct {
+		sql     string
+		columns []string
+	}{
+		// evaluated during planning, and the outer statement is planned as `Dual` on empty tables
+		{"SELECT * FROM customer WHERE c_d_id = 1 AND c_id = (SELECT MAX(o_c_id) FROM orders WHERE o_carrier_id = 5)", []string{"test.customer.c_d_id", "test.orders.o_carrier_id"}},
+		{"SELECT * FROM customer WHERE c_d_id = 1 AND EXISTS (SELECT * FROM orders WHERE o_carrier_id = 5)", []string{"test.customer.c_d_id", "test.orders.o_carrier_id"}},
+		{"SELECT * FROM customer c WHERE EXISTS (SELECT 1 FROM orders WHERE o_c_id = c.c_id AND o_carrier_id = 5)", []string{"", "test.orders.o_carrier_id"}},
+		{"SELECT * FROM customer WHERE c_id > ALL (SELECT o_c_id FROM orders WHERE o_carrier_id = 5)", []string{"test.orders.o_carrier_id"}},
+		{"WITH cte AS (SELECT c_id, c_last FROM customer WHERE c_d_id = 1) SELECT * FROM cte WHERE c_id = (SELECT MAX(c_id) FROM cte WHERE c_last = 'a')", []string{"test.customer.c_d_id", "test.customer.c_last"}},
+	}
+	for _, c := range cases {
+		constants, err := w.InferConstants(c.sql)
+		require.Nil(t, err, c.sql)
+		require.Len(t, constants, len(c.columns), c.sql)
+		for i, constant := range constants {
+			require.NotNil(t, constant.Type, "constant `%v` in `%s` not inferred", constant.Value, c.sql)
+			require.Equal(t, c.columns[i], constant.Type.ColumnName(), c.sql)
+		}
+	}
+
+	result := w.MaskOneResult("SELECT * FROM customer WHERE c_id = (SELECT MAX(o_c_id) FROM orders WHERE o_carrier_id = 5)")
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, "SELECT * FROM `test`.`customer` WHERE `c_id`=(SELECT MAX(`o_c_id`) FROM `orders` WHERE `o_carrier_id`=5)", result.Masked)
+}
+
+func TestMaskUnmappedNames(t *testing.T) {
+	t.Parallel()
+
+	db := newTestDB(t)
+	nameMap := NewGlobalNameMap(map[string]string{
+		"test.customer.c_id":   "db0.table0.col0",
+		"test.customer.c_last": "db0.table0.col2",
+	})
+	w := NewSQLWorker(db, MaskFuncMap["debug"], nil, false, nameMap)
+
+	// aliases are never in the map
+	sql := "SELECT c.c_id, c_balance AS b FROM customer c WHERE c_last = 'x' AND c_balance > @min ORDER BY b"
+	result := w.MaskOneResult(sql)
+	require.Equal(t, StatusSuccess, result.Status)
+	require.Equal(t, map[string]uint64{"column test.customer.c_balance": 2, "variable min": 1}, w.Stats.Unmapped)
+
+	stats := Stats{}
+	stats.Merge(w.Stats)
+	stats.Merge(w.Stats)
+	require.Equal(t, uint64(4), stats.Unmapped["column test.customer.c_balance"])
+	out := &strings.Builder{}
+	stats.PrintUnmapped(out)
+	require.Equal(t, "\n====Unmapped Names====\n4            column test.customer.c_balance\n2            variable min\n", out.String())
+
+	// statements with unmapped identifiers fail in strict mode
+	strictMap := NewGlobalNameMap(map[string]string{
+		"test.customer.c_id":   "db0.table0.col0",
+		"test.customer.c_last": "db0.table0.col2",
+	})
+	strictMap.SetStrict(true)
+	w = NewSQLWorker(db, MaskFuncMap["debug"], nil, false, strictMap)
+	result = w.MaskOneResult("SELECT c.c_id AS id FROM customer c WHERE c_last = 'x' ORDER BY id")
+	require.Equal(t, StatusSuccess, result.Status)
+	result = w.MaskOneResult(sql)
+	require.Equal(t, StatusFailed, result.Status)
+	require.Empty(t, result.Masked)
+	require.Contains(t, result.Errors[0], "column test.customer.c_balance")
+	result = w.MaskOneResult("SELECT * FROM orders WHERE o_id = 1")
+	require.Equal(t, StatusFailed, result.Status)
+	require.Contains(t, result.Errors[0], "table test.orders")
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/domain"
 	"github.com/pingcap/tidb/executor"
 	"github.com/pingcap/tidb/server"
 )
@@ -104,6 +106,16 @@ func (db *Context) RestoreSQL(node ast.Node) (string, error) {
 	return sql, nil
 }
 
+// Get the info of table `schema.table` in the current schema
+func (db *Context) TableInfo(schema string, table string) (*model.TableInfo, error) {
+	is := domain.GetDomain(db.qctx.Session).InfoSchema()
+	tbl, err := is.TableByName(model.NewCIStr(schema), model.NewCIStr(table))
+	if err != nil {
+		return nil, err
+	}
+	return tbl.Meta(), nil
+}
+
 func (db *Context) CurrentDB() string {
 	return db.qctx.GetSessionVars().CurrentDB
 }
@@ -0,0 +1,221 @@
+package tidb
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+const DefaultDelimiter = ";"
+
+type splitState int
+
+func quoteOf(state splitState) byte {
+	switch state {
+	case stateSingleQuote:
+		return '\''
+	case stateDoubleQuote:
+		return '"'
+	default:
+		return '`'
+	}
+}
+
+const (
+	stateNormal splitState = iota
+	stateSingleQuote
+	stateDoubleQuote
+	stateBackquote
+	stateLineComment
+	stateBlockComment
+)
+
+// Split SQL text from a reader into statements in a streaming manner, so that memory usage is
+// bounded by the size of a single statement.
+//
+// It follows the lexical rules of MySQL: delimiters inside quoted strings, identifiers and
+// comments are ignored, and the `DELIMITER` command of MySQL client is supported for stored
+// procedures. Delimiters are stripped from the returned statements, since custom ones are not
+// valid SQL. The last statement without a delimiter is also returned.
+type StatementReader struct {
+	reader    *bufio.Reader
+	delimiter string
+}
+
+func NewStatementReader(r io.Reader) *StatementReader {
+	return &StatementReader{
+		reader:    bufio.NewReader(r),
+		delimiter: DefaultDelimiter,
+	}
+}
+
+func (s *StatementReader) peekByte() (byte, bool) {
+	bs, err := s.reader.Peek(1)
+	if err != nil {
+		return 0, false
+	}
+	return bs[0], true
+}
+
+// Try to read a `DELIMITER xxx` command at the beginning of a statement
+func (s *StatementReader) mayReadDelimiterCommand(first byte) (bool, error) {
+	const command = "delimiter"
+	if first != 'd' && first != 'D' {
+		return false, nil
+	}
+	bs, _ := s.reader.Peek(len(command))
+	if len(bs) < len(command) || !strings.EqualFold(string(bs[:len(command)-1]), command[1:]) {
+		return false, nil
+	}
+	if c := bs[len(command)-1]; c != ' ' && c != '\t' {
+		return false, nil
+	}
+
+	line, err := s.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	delimiter := strings.TrimSpace(line[len(command)-1:])
+	if delimiter != "" {
+		s.delimiter = delimiter
+	}
+	return true, nil
+}
+
+func (s *StatementReader) finish(buf *bytes.Buffer, withDelimiter bool) string {
+	sql := buf.String()
+	if withDelimiter {
+		sql = strings.TrimSuffix(sql, s.delimiter)
+	}
+	return strings.TrimSpace(sql)
+}
+
+// Read the next statement, returns `io.EOF` if there's no more statements
+func (s *StatementReader) Next() (string, error) {
+	buf := &bytes.Buffer{}
+	state := stateNormal
+	firstContent := -1 // offset of the first byte other than spaces and comments
+	normalRun := 0     // count of trailing bytes read in normal state, for matching delimiters
+
+	markContent := func() {
+		if firstContent < 0 {
+			firstContent = buf.Len() - 1
+		}
+	}
+
+	for {
+		c, err := s.reader.ReadByte()
+		if err == io.EOF {
+			if firstContent >= 0 {
+				return s.finish(buf, false), nil
+			}
+			return "", io.EOF
+		} else if err != nil {
+			return "", err
+		}
+
+		if state == stateNormal && firstContent < 0 {
+			isCommand, err := s.mayReadDelimiterCommand(c)
+			if err != nil {
+				return "", err
+			}
+			if isCommand {
+				buf.Reset()
+				normalRun = 0
+				continue
+			}
+		}
+
+		_ = buf.WriteByte(c)
+
+		switch state {
+		case stateNormal:
+			next, hasNext := s.peekByte()
+			switch {
+			case c == '\'':
+				state = stateSingleQuote
+			case c == '"':
+				state = stateDoubleQuote
+			case c == '`':
+				state = stateBackquote
+			case c == '#':
+				state = stateLineComment
+			case c == '-' && hasNext && next == '-':
+				// `--` starts a comment only if followed by a space, a control character or EOF
+				_, _ = s.reader.ReadByte()
+				_ = buf.WriteByte(next)
+				following, hasFollowing := s.peekByte()
+				if !hasFollowing || following <= ' ' {
+					state = stateLineComment
+				} else {
+					markContent()
+					normalRun += 2
+					continue
+				}
+			case c == '/' && hasNext && next == '*':
+				_, _ = s.reader.ReadByte()
+				_ = buf.WriteByte(next)
+				state = stateBlockComment
+				// executable comments `/*! */` and optimizer hints `/*+ */` are contents
+				if following, ok := s.peekByte(); ok && (following == '!' || following == '+') {
+					markContent()
+				}
+			}
+
+			if state != stateNormal {
+				if state != stateLineComment && state != stateBlockComment {
+					markContent()
+				}
+				normalRun = 0
+				continue
+			}
+
+			if c > ' ' {
+				markContent()
+			}
+			normalRun += 1
+			if normalRun >= len(s.delimiter) && bytes.HasSuffix(buf.Bytes(), []byte(s.delimiter)) {
+				if firstContent >= buf.Len()-len(s.delimiter) {
+					// empty statement
+					buf.Reset()
+					firstContent = -1
+					normalRun = 0
+					continue
+				}
+				return s.finish(buf, true), nil
+			}
+
+		case stateSingleQuote, stateDoubleQuote, stateBackquote:
+			quote := quoteOf(state)
+			if c == '\\' && state != stateBackquote {
+				// skip the escaped character
+				if next, err := s.reader.ReadByte(); err == nil {
+					_ = buf.WriteByte(next)
+				}
+			} else if c == quote {
+				if next, ok := s.peekByte(); ok && next == quote {
+					// doubled quote
+					_, _ = s.reader.ReadByte()
+					_ = buf.WriteByte(next)
+				} else {
+					state = stateNormal
+				}
+			}
+
+		case stateLineComment:
+			if c == '\n' {
+				state = stateNormal
+			}
+
+		case stateBlockComment:
+			if c == '*' {
+				if next, ok := s.peekByte(); ok && next == '/' {
+					_, _ = s.reader.ReadByte()
+					_ = buf.WriteByte(next)
+					state = stateNormal
+				}
+			}
+		}
+	}
+}
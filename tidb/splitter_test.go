@@ -0,0 +1,176 @@
+package tidb
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllStatements(t *testing.T, text string) []string {
+	reader := NewStatementReader(strings.NewReader(text))
+	stmts := []string{}
+	for {
+		stmt, err := reader.Next()
+		if err == io.EOF {
+			return stmts
+		}
+		require.Nil(t, err)
+		stmts = append(stmts, stmt)
+	}
+}
+
+func restore(t *testing.T, node ast.Node) string {
+	buf := &strings.Builder{}
+	require.Nil(t, node.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, buf)))
+	return buf.String()
+}
+
+func TestStatementReader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"SELECT 1;\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"SELECT 'a;b', \"c;d\", `e;f`;", []string{"SELECT 'a;b', \"c;d\", `e;f`"}},
+		{"SELECT 'it''s;', 'it\\'s;';", []string{"SELECT 'it''s;', 'it\\'s;'"}},
+		{"SELECT 1 -- comment; here\n, 2;", []string{"SELECT 1 -- comment; here\n, 2"}},
+		{"SELECT 1 # comment; here\n, 2;", []string{"SELECT 1 # comment; here\n, 2"}},
+		{"SELECT 1 /* comment; here */, 2;", []string{"SELECT 1 /* comment; here */, 2"}},
+		{"SELECT 1--1;", []string{"SELECT 1--1"}},
+		{";; SELECT 1;;", []string{"SELECT 1"}},
+		{"-- only comments;\n/* here; */", []string{}},
+		{"/*!40101 SET NAMES utf8 */;", []string{"/*!40101 SET NAMES utf8 */"}},
+		{"", []string{}},
+		{
+			"DELIMITER //\nCREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END//\ndelimiter ;\nCALL p();",
+			[]string{"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END", "CALL p()"},
+		},
+		{
+			"DELIMITER $$\nSELECT '$$'$$ SELECT 2 $$",
+			[]string{"SELECT '$$'", "SELECT 2"},
+		},
+		{"SELECT * FROM delimiter_table;", []string{"SELECT * FROM delimiter_table"}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, readAllStatements(t, test.text), test.text)
+	}
+}
+
+func TestStatementReaderDelimiter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		// delimiters may be changed several times, in any case and with multiple characters
+		{
+			"DELIMITER ;;\nSELECT 1;;\nDeLiMiTeR //\nSELECT 2; SELECT 3//\nDELIMITER ;\nSELECT 4;",
+			[]string{"SELECT 1", "SELECT 2; SELECT 3", "SELECT 4"},
+		},
+		// custom delimiters in quotes and comments are ignored
+		{
+			"DELIMITER //\nSELECT '//', \"//\", `//` /* // */ -- //\n//",
+			[]string{"SELECT '//', \"//\", `//` /* // */ -- //"},
+		},
+		// the command takes effect only at the beginning of a statement
+		{
+			"SELECT 1 AS delimiter; DELIMITER $$\nSELECT 2$$",
+			[]string{"SELECT 1 AS delimiter", "SELECT 2"},
+		},
+		// a command at the end of input, or without a delimiter keeps the current one
+		{"SELECT 1;\nDELIMITER //", []string{"SELECT 1"}},
+		{"DELIMITER \nSELECT 1;", []string{"SELECT 1"}},
+		{"DELIMITER //  \nSELECT 1 //", []string{"SELECT 1"}},
+		// stored programs dumped by mysqldump
+		{
+			"DELIMITER ;;\n/*!50003 CREATE*/ /*!50020 DEFINER=`root`@`%`*/ /*!50003 TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; END */;;\nDELIMITER ;\n",
+			[]string{"/*!50003 CREATE*/ /*!50020 DEFINER=`root`@`%`*/ /*!50003 TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; END */"},
+		},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, readAllStatements(t, test.text), test.text)
+	}
+}
+
+func TestStatementReaderComments(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		// versioned comments and optimizer hints are contents, where delimiters are ignored
+		{"/*!40101 SET @a = 1; */;", []string{"/*!40101 SET @a = 1; */"}},
+		{"/*!40101 SET NAMES utf8 */; /*!40103 SET TIME_ZONE='+00:00' */;", []string{"/*!40101 SET NAMES utf8 */", "/*!40103 SET TIME_ZONE='+00:00' */"}},
+		{"SELECT /*+ HASH_JOIN(t1; t2) */ 1;", []string{"SELECT /*+ HASH_JOIN(t1; t2) */ 1"}},
+		// other comments are not, so statements of comments only are dropped
+		{"/* a; */; /*!*/; SELECT 1;", []string{"/*!*/", "SELECT 1"}},
+		{"/* unterminated; SELECT 1;", []string{}},
+		{"SELECT 1 /**/;/***/", []string{"SELECT 1 /**/"}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, readAllStatements(t, test.text), test.text)
+	}
+}
+
+func TestStatementReaderEscapes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"SELECT 'a\\\\'; SELECT 2;", []string{"SELECT 'a\\\\'", "SELECT 2"}},
+		{"SELECT 'a\\';'; SELECT 2;", []string{"SELECT 'a\\';'", "SELECT 2"}},
+		{"SELECT \"a\\\";\"; SELECT 2;", []string{"SELECT \"a\\\";\"", "SELECT 2"}},
+		{"SELECT 'a\"b;', \"c'd;\";", []string{"SELECT 'a\"b;', \"c'd;\""}},
+		// backslashes are not escapes in identifiers, and backquotes are doubled instead
+		{"SELECT 1 AS `a\\`; SELECT 2;", []string{"SELECT 1 AS `a\\`", "SELECT 2"}},
+		{"SELECT 1 AS `a``;`; SELECT 2;", []string{"SELECT 1 AS `a``;`", "SELECT 2"}},
+		// a trailing backslash in an unterminated string
+		{"SELECT 'a\\", []string{"SELECT 'a\\"}},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, readAllStatements(t, test.text), test.text)
+	}
+}
+
+// Statements split by the reader are the same as those split by the parser
+func TestStatementReaderParser(t *testing.T) {
+	t.Parallel()
+
+	texts := []string{
+		"SELECT 'a;b', \"c;d\", `e;f`; SELECT 'it''s;', 'it\\'s;';",
+		"SELECT 1 -- comment; here\n, 2; SELECT 1 # comment; here\n, 2;",
+		"SELECT 1--1; SELECT 1 /* comment; here */, 2;",
+		"/*!40101 SET NAMES utf8 */; SELECT /*+ HASH_JOIN(t1, t2) */ 1 FROM t1, t2;",
+		"SELECT 'a\\\\'; SELECT \"a\\\";\"; SELECT 1 AS `a\\`; SELECT 1 AS `a``;`;",
+		"INSERT INTO t VALUES ('x;y', 'z\\n;'), (\"\\\\\", 2); UPDATE t SET a = ';' WHERE b = '\\'';",
+	}
+
+	p := parser.New()
+	for _, text := range texts {
+		stmts := readAllStatements(t, text)
+		nodes, _, err := p.Parse(text, "", "")
+		require.Nil(t, err, text)
+		require.Len(t, stmts, len(nodes), text)
+		for i, stmt := range stmts {
+			node, err := p.ParseOneStmt(stmt, "", "")
+			require.Nil(t, err, stmt)
+			require.Equal(t, restore(t, nodes[i]), restore(t, node), stmt)
+		}
+	}
+}